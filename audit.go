@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type auditReport struct {
+	File     string        `json:"file"`
+	Error    string        `json:"error,omitempty"`
+	Findings []cfg.Finding `json:"findings"`
+}
+
+func auditCmd(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text, json (same as -json), or sarif")
+	failOn := fs.String("fail-on", "", "exit with status 1 if any finding is at least this severe (info, low, medium, high)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg audit [-format text|json|sarif] [-fail-on severity] <config.cfg|wrapper.json>...")
+		fs.PrintDefaults()
+	}
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		failUsage(fs, "audit needs at least one file")
+	}
+	switch *format {
+	case "json":
+		// One JSON form: the result envelope that every command prints with -json
+		jsonOutput = true
+	case "sarif":
+		if jsonOutput {
+			failUsage(fs, "-json can't be used with -format sarif")
+		}
+	case "text":
+	default:
+		failUsage(fs, fmt.Sprintf("unknown format %q", *format))
+	}
+
+	threshold := cfg.Severity(-1)
+	if *failOn != "" {
+		var err error
+		if threshold, err = cfg.ParseSeverity(*failOn); err != nil {
+			failUsage(fs, err.Error())
+		}
+	}
+
+	var reports []auditReport
+	failed := false
+	for _, name := range fs.Args() {
+		r := auditReport{File: name, Findings: []cfg.Finding{}}
+		configBytes, _, err := readConfig(name)
+		if err == nil {
+			var findings []cfg.Finding
+			if findings, err = cfg.Audit(configBytes); err == nil {
+				r.Findings = findings
+			}
+		}
+		if err != nil {
+			// Keep going so that one bad file doesn't hide the results for the others.
+			r.Error = err.Error()
+			failed = true
+		}
+		for _, f := range r.Findings {
+			if threshold >= 0 && f.Severity >= threshold {
+				failed = true
+			}
+		}
+		reports = append(reports, r)
+	}
+
+	if jsonOutput {
+		done(reports, nil, nil, failed, nil)
+		return
+	}
+	if *format == "sarif" {
+		if err := printJSON(toSarif(reports)); err != nil {
+			fail(err, nil, nil)
+		}
+	} else {
+		printAuditText(reports)
+	}
+	if failed {
+		os.Exit(exitFailed)
+	}
+}
+
+func printAuditText(reports []auditReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range reports {
+		fmt.Fprintf(w, "%s:\n", r.File)
+		if r.Error != "" {
+			fmt.Fprintf(w, "  error: %s\n", r.Error)
+			continue
+		}
+		if len(r.Findings) == 0 {
+			fmt.Fprintln(w, "  no findings")
+		}
+		for _, f := range r.Findings {
+			fmt.Fprintf(w, "  %s\t%s\t%s=%s\t%s\n", f.Severity, f.Rule, f.Key, f.Value, f.Message)
+		}
+	}
+	w.Flush()
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(b, '\n'))
+	return err
+}
+
+// Minimal subset of SARIF 2.1.0 (https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html)
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	DefaultConfig    sarifConfig  `json:"defaultConfiguration"`
+}
+
+type sarifConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// sarifErrorRule is the ID of the rule for files that couldn't be audited.
+const sarifErrorRule = "error"
+
+func sarifLevel(s cfg.Severity) string {
+	switch s {
+	case cfg.SeverityHigh:
+		return "error"
+	case cfg.SeverityMedium:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func toSarif(reports []auditReport) sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "orbicfg",
+			InformationURI: "https://github.com/Fysac/orbicfg",
+		}},
+		Results: []sarifResult{},
+	}
+	for _, r := range cfg.AuditRules() {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               r.ID,
+			ShortDescription: sarifMessage{Text: r.Description},
+			DefaultConfig:    sarifConfig{Level: sarifLevel(r.Severity)},
+		})
+	}
+	// Files that couldn't be audited are reported as results of this rule
+	run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+		ID:               sarifErrorRule,
+		ShortDescription: sarifMessage{Text: "The config couldn't be read or audited"},
+		DefaultConfig:    sarifConfig{Level: "error"},
+	})
+
+	for _, r := range reports {
+		location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: r.File}}}
+		if r.Error != "" {
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    sarifErrorRule,
+				Level:     "error",
+				Message:   sarifMessage{Text: r.Error},
+				Locations: []sarifLocation{location},
+			})
+			continue
+		}
+		for _, f := range r.Findings {
+			loc := location
+			loc.LogicalLocations = []sarifLogicalLocation{{Name: f.Key, Kind: "member"}}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    f.Rule,
+				Level:     sarifLevel(f.Severity),
+				Message:   sarifMessage{Text: fmt.Sprintf("%s (%s=%s)", f.Message, f.Key, f.Value)},
+				Locations: []sarifLocation{loc},
+			})
+		}
+	}
+
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type batchOptions struct {
+	dir    string
+	jobs   int
+	report string
+}
+
+// addBatchFlags defines the flags of the decrypt and encrypt commands for processing a directory.
+func addBatchFlags(fs *flag.FlagSet) *batchOptions {
+	b := &batchOptions{}
+	fs.StringVar(&b.dir, "batch", "", "process every file in this `directory`, mirroring its tree to the output directory")
+	fs.IntVar(&b.jobs, "jobs", runtime.NumCPU(), "number of files to process in parallel with -batch")
+	fs.StringVar(&b.report, "report", "", "write the JSON report of -batch to this file instead of stdout")
+	return b
+}
+
+type batchResult struct {
+	Input      string        `json:"input"`
+	Output     string        `json:"output"`
+	OK         bool          `json:"ok"`
+	Error      string        `json:"error,omitempty"`
+	ErrorCode  int           `json:"error_code,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	Metadata   *cfg.Metadata `json:"metadata,omitempty"`
+}
+
+type batchReport struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Files     []batchResult `json:"files"`
+}
+
+// decryptedName maps an encrypted config to the name of its wrapper.
+func decryptedName(rel string) (string, bool) {
+	return rel + ".json", true
+}
+
+// encryptedName maps a wrapper to the name of its encrypted config. Other files are skipped.
+func encryptedName(rel string) (string, bool) {
+	if !strings.HasSuffix(rel, ".json") || strings.HasSuffix(rel, secretsFileSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(rel, ".json"), true
+}
+
+// runBatch calls process on every file under b.dir that outputName accepts, using at most b.jobs goroutines.
+// Failures are recorded in the report instead of stopping the batch; the exit code is 1 if any file failed.
+func runBatch(fs *flag.FlagSet, b *batchOptions, outputDir string, outputName func(rel string) (string, bool), process func(in, out string) ([]string, *cfg.Metadata, error)) {
+	if b.jobs < 1 {
+		failUsage(fs, "-jobs must be at least 1")
+	}
+
+	// Collect the files first, so outputs written inside the input directory aren't picked up
+	var results []batchResult
+	err := filepath.WalkDir(b.dir, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		if out, ok := outputName(rel); ok {
+			results = append(results, batchResult{Input: path, Output: filepath.Join(outputDir, out)})
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err, nil, nil)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < b.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				r := &results[i]
+				err := os.MkdirAll(filepath.Dir(r.Output), 0700)
+				if err == nil {
+					r.Warnings, r.Metadata, err = process(r.Input, r.Output)
+				}
+				if err != nil {
+					r.Error = err.Error()
+					r.ErrorCode = exitCode(err)
+					r.ErrorClass = errorClasses[r.ErrorCode]
+				} else {
+					r.OK = true
+				}
+			}
+		}()
+	}
+	for i := range results {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := batchReport{Files: results}
+	for _, r := range results {
+		if r.OK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+
+	if b.report != "" {
+		reportJSON, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			fail(err, nil, nil)
+		}
+		if err := writeFileNoTrunc(b.report, append(reportJSON, '\n')); err != nil {
+			fail(err, nil, nil)
+		}
+	}
+	if jsonOutput {
+		done(report, nil, nil, report.Failed > 0, nil)
+		return
+	}
+
+	for _, r := range results {
+		if r.OK {
+			l.Printf("ok    %s -> %s", r.Input, r.Output)
+		} else {
+			l.Printf("FAIL  %s: %s", r.Input, r.Error)
+		}
+	}
+	l.Printf("%v succeeded, %v failed", report.Succeeded, report.Failed)
+	if b.report == "" {
+		if err := printJSON(report); err != nil {
+			fail(err, nil, nil)
+		}
+	}
+	if report.Failed > 0 {
+		os.Exit(exitFailed)
+	}
+}
@@ -0,0 +1,278 @@
+package cfg
+
+import (
+	"fmt"
+	"strings"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+)
+
+var severityNames = []string{"info", "low", "medium", "high"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	parsed, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q (must be one of %s)", name, strings.Join(severityNames, ", "))
+}
+
+// Finding is a risky setting found by Audit.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Key      string   `json:"key"`
+	Value    string   `json:"value"`
+	Message  string   `json:"message"`
+}
+
+// AuditRule checks a config for one kind of risky setting.
+type AuditRule struct {
+	ID          string
+	Description string
+	Severity    Severity
+
+	check func(config *orderedmap.OrderedMap[string, string]) []keyValue
+}
+
+type keyValue struct {
+	key, value string
+}
+
+// keysEqual returns a check that matches when any of the given keys has the given value.
+func keysEqual(value string, keys ...string) func(*orderedmap.OrderedMap[string, string]) []keyValue {
+	return func(config *orderedmap.OrderedMap[string, string]) []keyValue {
+		var matches []keyValue
+		for _, k := range keys {
+			if v, ok := config.Get(k); ok && v == value {
+				matches = append(matches, keyValue{k, v})
+			}
+		}
+		return matches
+	}
+}
+
+// keysMatch returns a check that matches every key for which match returns true.
+func keysMatch(match func(key, value string) bool) func(*orderedmap.OrderedMap[string, string]) []keyValue {
+	return func(config *orderedmap.OrderedMap[string, string]) []keyValue {
+		var matches []keyValue
+		for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+			if match(pair.Key, pair.Value) {
+				matches = append(matches, keyValue{pair.Key, pair.Value})
+			}
+		}
+		return matches
+	}
+}
+
+var auditRules = []*AuditRule{
+	{
+		ID:          "remote-management",
+		Description: "Remote management is enabled",
+		Severity:    SeverityHigh,
+		check:       keysEqual("1", "remote_endis", "firewall.remote_mgmt.enable"),
+	},
+	{
+		ID:          "remote-management-anyone",
+		Description: "Remote management is open to every address",
+		Severity:    SeverityHigh,
+		check:       remoteManagementAnyone,
+	},
+	{
+		ID:          "wep",
+		Description: "A Wi-Fi network uses WEP",
+		Severity:    SeverityHigh,
+		check:       wepNetworks,
+	},
+	{
+		ID:          "empty-passphrase",
+		Description: "A secured Wi-Fi network has an empty passphrase",
+		Severity:    SeverityHigh,
+		check:       emptyPassphrases,
+	},
+	{
+		ID:          "tkip",
+		Description: "TKIP encryption is allowed",
+		Severity:    SeverityMedium,
+		check: keysMatch(func(key, value string) bool {
+			return (strings.HasSuffix(key, "_wpae_mode") || strings.HasSuffix(key, "_wpa_mode") || strings.HasSuffix(key, ".security_type")) &&
+				strings.Contains(strings.ToUpper(value), "TKIP")
+		}),
+	},
+	{
+		ID:          "wps",
+		Description: "WPS is enabled",
+		Severity:    SeverityMedium,
+		check: keysEqual("1", "endis_wl_wps", "endis_wla_wps", "endis_wlg_ap_bh_wps", "endis_wla_2nd_ap_bh_wps",
+			"wireless.radio.wps_enable", "wireless.radio.wps_pin_enable"),
+	},
+	{
+		ID:          "upnp",
+		Description: "UPnP is enabled",
+		Severity:    SeverityMedium,
+		check:       keysEqual("1", "upnp_enable", "upnp.config.enable"),
+	},
+	{
+		ID:          "tr069",
+		Description: "A TR-069 ACS is configured",
+		Severity:    SeverityMedium,
+		check: keysMatch(func(key, value string) bool {
+			return (key == "cwmp_acs_url" && value != "") || (key == "cwmp_tr069_enable" && value == "1")
+		}),
+	},
+	{
+		ID:          "wan-ping",
+		Description: "The router responds to ping on the WAN port",
+		Severity:    SeverityLow,
+		check:       keysEqual("1", "wan_endis_rspToPing", "firewall.basic.respond_ping_enable"),
+	},
+	{
+		ID:          "default-admin-user",
+		Description: "The admin user has the default name",
+		Severity:    SeverityLow,
+		check:       keysEqual("admin", "http_username", "system.http.username"),
+	},
+}
+
+// Security types (flat schema) and their values (dotted schema) that don't use a passphrase
+const (
+	flatSecTypeNone   = "1"
+	dottedSecTypeNone = "None"
+)
+
+// Security type of WEP networks (flat schema). WEP keys may be left over from an old setting, so only this counts.
+const flatSecTypeWEP = "2"
+
+// Who may use remote management once it's enabled: 0 is a single address and 1 a range (flat schema)
+const (
+	flatRemoteAccessAnyone   = "2"
+	dottedRemoteAccessAnyone = "any"
+)
+
+func remoteManagementAnyone(config *orderedmap.OrderedMap[string, string]) []keyValue {
+	var matches []keyValue
+	if v, _ := config.Get("remote_endis"); v == "1" {
+		if access, _ := config.Get("remote_access"); access == flatRemoteAccessAnyone {
+			matches = append(matches, keyValue{"remote_access", access})
+		}
+	}
+	if v, _ := config.Get("firewall.remote_mgmt.enable"); v == "1" {
+		if access, _ := config.Get("firewall.remote_mgmt.ip_addr_range"); access == dottedRemoteAccessAnyone {
+			matches = append(matches, keyValue{"firewall.remote_mgmt.ip_addr_range", access})
+		}
+	}
+	return matches
+}
+
+func wepNetworks(config *orderedmap.OrderedMap[string, string]) []keyValue {
+	var matches []keyValue
+	for _, n := range flatWifiNetworks {
+		if secType, _ := config.Get(n.prefix + "_sectype"); secType == flatSecTypeWEP {
+			matches = append(matches, keyValue{n.prefix + "_sectype", secType})
+		}
+	}
+	for _, n := range dottedWifiNetworks {
+		key := "wireless." + n.section + ".security_type"
+		if secType, _ := config.Get(key); strings.Contains(strings.ToUpper(secType), "WEP") {
+			matches = append(matches, keyValue{key, secType})
+		}
+	}
+	return matches
+}
+
+func emptyPassphrases(config *orderedmap.OrderedMap[string, string]) []keyValue {
+	var matches []keyValue
+	for _, n := range flatWifiNetworks {
+		secType, ok := config.Get(n.prefix + "_sectype")
+		if !ok || secType == flatSecTypeNone {
+			continue
+		}
+		empty := true
+		for _, psk := range []string{"_wpa2_psk", "_wpa1_psk", "_wpas_psk", "_wpa_psk"} {
+			if v, _ := config.Get(n.prefix + psk); v != "" {
+				empty = false
+			}
+		}
+		if empty {
+			matches = append(matches, keyValue{n.prefix + "_wpa2_psk", ""})
+		}
+	}
+	for _, n := range dottedWifiNetworks {
+		secType, ok := config.Get("wireless." + n.section + ".security_type")
+		if !ok || secType == "" || secType == dottedSecTypeNone {
+			continue
+		}
+		if v, _ := config.Get("wireless." + n.section + ".password"); v == "" {
+			matches = append(matches, keyValue{"wireless." + n.section + ".password", ""})
+		}
+	}
+	return matches
+}
+
+func AuditRules() []*AuditRule {
+	return auditRules
+}
+
+// Audit checks a decrypted config for risky settings.
+// Findings are ordered from most to least severe.
+func Audit(configBytes []byte) ([]Finding, error) {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	model := IdentifyModel(config)
+
+	findings := []Finding{}
+	for sev := SeverityHigh; sev >= SeverityInfo; sev-- {
+		for _, r := range auditRules {
+			if r.Severity != sev {
+				continue
+			}
+			for _, m := range r.check(config) {
+				if IsSecretKey(m.key, model) {
+					// Audit reports are meant to be shared.
+					m.value = placeholder(m.value)
+				}
+				findings = append(findings, Finding{
+					Rule:     r.ID,
+					Severity: r.Severity,
+					Key:      m.key,
+					Value:    m.value,
+					Message:  r.Description,
+				})
+			}
+		}
+	}
+	return findings, nil
+}
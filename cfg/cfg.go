@@ -2,13 +2,15 @@ package cfg
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 
-	"github.com/fysac/orbicfg/rand/musl"
-	"github.com/fysac/orbicfg/rand/uclibc"
 	orderedmap "github.com/wk8/go-ordered-map/v2"
 )
 
@@ -17,6 +19,17 @@ const (
 	RngUclibc = "uclibc"
 	RngMusl   = "musl"
 
+	// Byte orders of the header, keystream words, and checksum.
+	// Big-endian is used by MIPS devices.
+	EndianLittle = "little"
+	EndianBig    = "big"
+
+	// How a config was exported from the device.
+	// SOAP downloads have no container, so they can't be told apart from bare configs by their content.
+	OriginWeb  = "web"  // From the web interface, inside a photos.tar container
+	OriginSoap = "soap" // Downloaded over SOAP
+	OriginBare = "bare" // No container, from an unknown source (SOAP, or the web interface of some devices)
+
 	// When a config is exported from the web interface, it looks like a tar archive.
 	tarMarker = "photos.tar"
 
@@ -28,7 +41,8 @@ const (
 	// A header of this size immediately precedes the encrypted data.
 	headerSize = 12
 
-	// Data is encrypted in blocks of this size.
+	// Data is encrypted in blocks of this size, unless the metadata says otherwise.
+	// The checksum is always calculated over blocks of this size.
 	chunkSize = 4
 
 	// The starting and ending value when calculating and verifying a checksum, respectively.
@@ -37,6 +51,9 @@ const (
 
 var ErrInvalidChecksum = errors.New("invalid checksum")
 
+// ErrMetadataModified is returned by FromJSON when the metadata of a wrapper no longer matches its recorded digest.
+var ErrMetadataModified = errors.New("metadata was modified after decryption")
+
 type Header struct {
 	// Seed given to uClibc srand() to generate XOR keystream.
 	// e.g., 0x20131224 or 0x23091293
@@ -50,11 +67,17 @@ type Header struct {
 	Crc uint32
 }
 
+// Bytes returns the header as it's stored in a little-endian encrypted config.
 func (header *Header) Bytes() []byte {
+	return header.BytesOrder(binary.LittleEndian)
+}
+
+// BytesOrder returns the header as it's stored in an encrypted config with the given byte order.
+func (header *Header) BytesOrder(order binary.ByteOrder) []byte {
 	headerBytes := make([]byte, headerSize)
-	binary.LittleEndian.PutUint32(headerBytes[:4], header.Magic)
-	binary.LittleEndian.PutUint32(headerBytes[4:8], header.Len)
-	binary.LittleEndian.PutUint32(headerBytes[8:headerSize], header.Crc)
+	order.PutUint32(headerBytes[:4], header.Magic)
+	order.PutUint32(headerBytes[4:8], header.Len)
+	order.PutUint32(headerBytes[8:headerSize], header.Crc)
 	return headerBytes
 }
 
@@ -71,53 +94,278 @@ type Metadata struct {
 
 	// The rand(3) implementation to use. Can be 'uclibc' or 'musl'
 	Rng string `json:"rng"`
+
+	// Byte order of the header, keystream words, and checksum. Can be 'little' or 'big'; empty means 'little'
+	Endian string `json:"endian,omitempty"`
+
+	// Size in bytes of the words XORed with each call to rand(). Can be 4 or 8; 0 means 4
+	WordSize int `json:"word_size,omitempty"`
+
+	// How the config was exported. Can be 'web', 'soap', or 'bare'; see OriginWeb.
+	// Decrypt reports 'web' or 'bare' depending on HeaderOffset; it's only 'soap' if set by the user.
+	// Empty in version 1 wrappers, which FromJSON migrates by deriving it from HeaderOffset.
+	Origin string `json:"origin,omitempty"`
+
+	// Data found after the encrypted config, if any
+	Trailer *Trailer `json:"trailer,omitempty"`
+}
+
+// ByteOrder returns the byte order given by Endian.
+func (m *Metadata) ByteOrder() (binary.ByteOrder, error) {
+	switch m.Endian {
+	case "", EndianLittle:
+		return binary.LittleEndian, nil
+	case EndianBig:
+		return binary.BigEndian, nil
+	}
+	return nil, fmt.Errorf("unsupported endian %q", m.Endian)
+}
+
+func (m *Metadata) wordSize() (int, error) {
+	switch m.WordSize {
+	case 0:
+		return chunkSize, nil
+	case 4, 8:
+		return m.WordSize, nil
+	}
+	return 0, fmt.Errorf("unsupported word size %v", m.WordSize)
+}
+
+// canonical returns a copy of the metadata with default values left empty and the origin detectable
+// from the encrypted config, as Decrypt returns it.
+func (m Metadata) canonical() Metadata {
+	if m.Endian == EndianLittle {
+		m.Endian = ""
+	}
+	if m.WordSize == chunkSize {
+		m.WordSize = 0
+	}
+	m.Origin = containerOrigin(m.HeaderOffset)
+	return m
+}
+
+// containerOrigin returns the origin that Decrypt detects for a config with the given header offset.
+func containerOrigin(headerOffset uint64) string {
+	if headerOffset != 0 {
+		return OriginWeb
+	}
+	return OriginBare
+}
+
+// checkOrigin checks that Origin is valid and agrees with HeaderOffset.
+func (m *Metadata) checkOrigin() error {
+	switch m.Origin {
+	case "":
+		return nil
+	case OriginWeb, OriginSoap, OriginBare:
+	default:
+		return fmt.Errorf("unsupported origin %q", m.Origin)
+	}
+	if (m.Origin == OriginWeb) != (m.HeaderOffset != 0) {
+		return fmt.Errorf("origin %q doesn't match header offset %v", m.Origin, m.HeaderOffset)
+	}
+	return nil
+}
+
+// withOrigin returns a copy of the metadata for the same config exported with the given origin.
+func (m *Metadata) withOrigin(origin string) (*Metadata, error) {
+	c := *m
+	c.Origin = origin
+	switch origin {
+	case OriginWeb:
+		if c.HeaderOffset == 0 {
+			c.HeaderOffset = configOffsetAfterTar
+		}
+	case OriginSoap, OriginBare:
+		c.HeaderOffset = 0
+	default:
+		return nil, fmt.Errorf("unsupported origin %q", origin)
+	}
+	return &c, nil
+}
+
+// cipherVariants are the byte orders and word sizes tried by Decrypt, most common first.
+var cipherVariants = []Metadata{
+	{},
+	{WordSize: 8},
+	{Endian: EndianBig},
+	{Endian: EndianBig, WordSize: 8},
+}
+
+// Trailer is data that follows the encrypted config, e.g., a signature block or padding added in transit.
+// Encrypt re-appends it so the re-encrypted file has the same layout.
+type Trailer struct {
+	Len    uint64 `json:"len"`
+	SHA256 string `json:"sha256"`
+
+	// Only preserved if the trailer is at most maxTrailerData bytes
+	Data []byte `json:"data,omitempty"`
+}
+
+// Trailers larger than this are only recorded by length and hash.
+const maxTrailerData = 64 * 1024
+
+func newTrailer(data []byte) *Trailer {
+	sum := sha256.Sum256(data)
+	t := &Trailer{Len: uint64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	if len(data) <= maxTrailerData {
+		t.Data = data
+	}
+	return t
+}
+
+// bytes returns the content of the trailer. If only its hash was preserved,
+// the trailer can still be recreated if it consisted entirely of null bytes.
+func (t *Trailer) bytes() ([]byte, error) {
+	if t.Data != nil {
+		return t.Data, nil
+	}
+	zeros := make([]byte, t.Len)
+	sum := sha256.Sum256(zeros)
+	if hex.EncodeToString(sum[:]) != t.SHA256 {
+		return nil, fmt.Errorf("content of %v-byte trailer was not preserved", t.Len)
+	}
+	return zeros, nil
+}
+
+// ProtectedKeyError is returned by FromJSON when a protected key was changed after decryption.
+type ProtectedKeyError struct {
+	Key      string
+	Original string
+	Current  string
+	Deleted  bool
+}
+
+func (e *ProtectedKeyError) Error() string {
+	if e.Deleted {
+		return fmt.Sprintf("protected key %s was deleted", e.Key)
+	}
+	return fmt.Sprintf("protected key %s was changed from %q to %q", e.Key, e.Original, e.Current)
+}
+
+// Integrity lets FromJSON detect accidental edits to the metadata of a wrapper.
+type Integrity struct {
+	// SHA-256 of the metadata as it was at decryption
+	MetadataSHA256 string `json:"metadata_sha256"`
+
+	// SHA-256 of the encrypted config the wrapper was decrypted from, if known
+	SourceSHA256 string `json:"source_sha256,omitempty"`
 }
 
 type wrapper struct {
-	Metadata  *Metadata                              `json:"metadata"`
+	// See WrapperVersion
+	Version int `json:"version,omitempty"`
+
+	Metadata *Metadata `json:"metadata"`
+
+	Integrity *Integrity `json:"integrity,omitempty"`
+
+	Provenance *Provenance `json:"provenance,omitempty"`
+
+	// Original values of the model's protected keys, recorded at decryption
+	Protected map[string]string `json:"protected,omitempty"`
+
+	// Set if the config was recovered from a damaged one by Salvage
+	Salvage *SalvageReport `json:"salvage,omitempty"`
+
+	// Keys whose values were replaced with placeholders by Redact
+	Redacted []string `json:"redacted,omitempty"`
+
 	Config    *orderedmap.OrderedMap[string, string] `json:"config,omitempty"`
 	ConfigRaw []byte                                 `json:"config_raw,omitempty"`
 }
 
-func Decrypt(encryptedConfig []byte) (header *Header, configBytes []byte, metadata *Metadata, err error) {
-	var offset uint64 = 0
-	if bytes.HasPrefix(encryptedConfig, []byte(tarMarker)) {
-		if len(encryptedConfig) <= configOffsetAfterTar {
-			err = fmt.Errorf("offset should be %v, but config is too small (%v)", configOffsetAfterTar, len(encryptedConfig))
-			return
+// Decrypt decrypts an encrypted config, detecting its RNG, byte order, and word size.
+// Supported options: WithTracer.
+func Decrypt(encryptedConfig []byte, opts ...Option) (header *Header, configBytes []byte, metadata *Metadata, err error) {
+	o := newOptions(opts)
+	o.tracef("input is %v bytes", len(encryptedConfig))
+	for i, variant := range cipherVariants {
+		h, c, m, vErr := decryptVariant(encryptedConfig, variant, o)
+		if vErr == nil {
+			o.tracef("decrypted with rng %s, real magic %#08x", m.Rng, m.RealMagic)
+			return h, c, m, nil
+		}
+		o.tracef("variant failed: %v", vErr)
+		// If nothing works, report on the first variant whose header could at least be parsed
+		if i == 0 || header == nil && h != nil {
+			header, configBytes, metadata, err = h, c, m, vErr
 		}
-		offset = configOffsetAfterTar
 	}
+	return
+}
 
-	header, err = parseHeader(encryptedConfig[offset:])
+// decryptVariant decrypts an encrypted config using the byte order and word size of variant.
+func decryptVariant(encryptedConfig []byte, variant Metadata, o *options) (header *Header, configBytes []byte, metadata *Metadata, err error) {
+	order, err := variant.ByteOrder()
+	if err != nil {
+		return
+	}
+	wordSize, err := variant.wordSize()
+	if err != nil {
+		return
+	}
+	endian := variant.Endian
+	if endian == "" {
+		endian = EndianLittle
+	}
+	o.tracef("trying %s-endian header and %v-byte words", endian, wordSize)
+	offset, header, err := locateHeader(encryptedConfig, order)
 	if err != nil {
 		return
 	}
+	if offset != 0 {
+		o.tracef("  found %q container; header at offset %v", tarMarker, offset)
+	} else {
+		o.tracef("  no container; header at offset 0")
+	}
+	o.tracef("  header: magic %#08x, len %v, crc %#08x", header.Magic, header.Len, header.Crc)
+
+	var trailer *Trailer
+	if extra := encryptedConfig[offset+headerSize+uint64(header.Len):]; len(extra) > 0 {
+		trailer = newTrailer(extra)
+	}
 
-	// The magic value in the header is sometimes incorrect; check if we have an override for it
 	if override, ok := Overrides()[header.Magic]; ok {
-		metadata = override
-		// Decrypt using the values in the override
-		configBytes, err = xorCipher(header, encryptedConfig[offset+headerSize:], metadata)
-		if err != nil {
-			return
-		}
-		err = verifyChecksum(header, configBytes)
-		return
+		o.tracef("  override for magic %#08x: rng %s, real magic %#08x", header.Magic, override.Rng, override.RealMagic)
+	} else {
+		o.tracef("  no override for magic %#08x", header.Magic)
 	}
 
-	// No overrides; take the header at face value
-	metadata = &Metadata{HeaderOffset: offset, StatedMagic: header.Magic, RealMagic: header.Magic}
+	candidates := rngCandidates(offset, header.Magic, variant)
+	for i, candidate := range candidates {
+		metadata = candidate
+		metadata.Trailer = trailer
+		o.tracef("  trying rng %s with magic %#08x", metadata.Rng, metadata.RealMagic)
+
+		// Skip RNGs that clearly produce garbage, unless there are none left to try
+		if i < len(candidates)-1 && header.Len >= plausibleLen {
+			var prefix []byte
+			prefix, err = xorCipher(&Header{Len: plausibleLen}, encryptedConfig[offset+headerSize:], metadata)
+			if err != nil {
+				return
+			}
+			if !isPlausible(prefix) {
+				o.tracef("    skipped: first %v bytes aren't plausible text", plausibleLen)
+				err = ErrInvalidChecksum
+				continue
+			}
+		}
 
-	// Try to decrypt using each supported RNG
-	for _, rng := range []string{RngMusl, RngUclibc} {
-		metadata.Rng = rng
-		configBytes, err = xorCipher(header, encryptedConfig[offset+headerSize:], metadata)
-		if err != nil {
+		var keystream []byte
+		if keystream, err = getKeystream(metadata, int(header.Len)); err != nil {
 			return
 		}
-
-		if err = verifyChecksum(header, configBytes); err == nil {
+		configBytes = make([]byte, header.Len)
+		xorBytes(configBytes, encryptedConfig[offset+headerSize:], keystream)
+		o.traceDump(configBytes)
+
+		// The checksum counts down from initialCrc, so the residue is 0 if it matches
+		crc := Checksum(configBytes, order)
+		o.tracef("    checksum %#08x, residue %#08x", crc, crc-header.Crc)
+		if err = VerifyChecksum(header, configBytes, order); err == nil {
+			// Only keystreams that produced a valid config are cached, so junk magics can't fill the cache
+			cacheKeystream(metadata, keystream)
 			// No need to try other RNGs if the checksum is good
 			break
 		}
@@ -125,12 +373,54 @@ func Decrypt(encryptedConfig []byte) (header *Header, configBytes []byte, metada
 	return
 }
 
-func Encrypt(configBytes []byte, metadata *Metadata) ([]byte, error) {
+// rngCandidates returns the possible metadata of a config with the given header magic and cipher variant.
+func rngCandidates(offset uint64, magic uint32, variant Metadata) []*Metadata {
+	var candidates []*Metadata
+	// The magic value in the header is sometimes incorrect; check if we have an override for it
+	if override, ok := Overrides()[magic]; ok {
+		// Copy so that the override itself isn't modified
+		o := *override
+		o.HeaderOffset = offset
+		candidates = append(candidates, &o)
+	} else {
+		// No overrides; take the header at face value and try each supported RNG
+		for _, rng := range []string{RngMusl, RngUclibc} {
+			candidates = append(candidates, &Metadata{HeaderOffset: offset, StatedMagic: magic, RealMagic: magic, Rng: rng})
+		}
+	}
+	for _, m := range candidates {
+		m.Endian = variant.Endian
+		m.WordSize = variant.WordSize
+		m.Origin = containerOrigin(m.HeaderOffset)
+	}
+	return candidates
+}
+
+// Encrypt encrypts a decrypted config. Supported options: NoValidate, WithOrigin.
+func Encrypt(configBytes []byte, metadata *Metadata, opts ...Option) ([]byte, error) {
+	o := newOptions(opts)
 	if len(configBytes) == 0 {
 		return nil, errors.New("config is empty")
 	}
-	if len(configBytes)%chunkSize != 0 {
-		return nil, errors.New("config length is not divisible by chunk size")
+	metadata, err := o.targetMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	order, err := metadata.ByteOrder()
+	if err != nil {
+		return nil, err
+	}
+	wordSize, err := metadata.wordSize()
+	if err != nil {
+		return nil, err
+	}
+	if len(configBytes)%wordSize != 0 {
+		return nil, fmt.Errorf("config length is not divisible by word size (%v)", wordSize)
+	}
+	if !o.noValidate {
+		if err := Validate(configBytes); err != nil {
+			return nil, fmt.Errorf("validate config: %w", err)
+		}
 	}
 
 	header := Header{
@@ -139,74 +429,122 @@ func Encrypt(configBytes []byte, metadata *Metadata) ([]byte, error) {
 		magic actually used for encryption. */
 		Magic: metadata.StatedMagic,
 		Len:   uint32(len(configBytes)),
-		Crc:   calcChecksum(configBytes),
+		Crc:   Checksum(configBytes, order),
 	}
 
 	encryptedConfig, err := xorCipher(&header, configBytes, metadata)
 	if err != nil {
 		return nil, err
 	}
-	encryptedConfig = append(header.Bytes(), encryptedConfig...)
+	encryptedConfig = append(header.BytesOrder(order), encryptedConfig...)
 
 	if metadata.HeaderOffset != 0 {
 		encryptedConfig = prependJunk(encryptedConfig, metadata.HeaderOffset)
 	}
+	if metadata.Trailer != nil {
+		trailer, err := metadata.Trailer.bytes()
+		if err != nil {
+			return nil, err
+		}
+		encryptedConfig = append(encryptedConfig, trailer...)
+	}
 	return encryptedConfig, nil
 }
 
-func xorCipher(header *Header, input []byte, metadata *Metadata) ([]byte, error) {
-	var randFunc func() int32
-	switch metadata.Rng {
-	case RngUclibc:
-		uclibc.Srand(metadata.RealMagic)
-		randFunc = uclibc.Rand
-	case RngMusl:
-		musl.Srand(metadata.RealMagic)
-		randFunc = musl.Rand
+// ErrSalvaged is returned by FromJSON for wrappers created by Salvage, which must be reviewed before encryption.
+var ErrSalvaged = errors.New("config was salvaged from a damaged backup; review it, then remove 'salvage' from the wrapper")
+
+// ErrVerificationFailed is returned by EncryptVerified when the encrypted config doesn't decrypt back to its input.
+var ErrVerificationFailed = errors.New("encrypted config failed verification")
+
+// EncryptVerified is like Encrypt, but also decrypts the encrypted config using the normal detection path
+// (including overrides) and checks that the checksum, metadata, and entries all match the input.
+func EncryptVerified(configBytes []byte, metadata *Metadata, opts ...Option) ([]byte, error) {
+	encryptedConfig, err := Encrypt(configBytes, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if metadata, err = newOptions(opts).targetMetadata(metadata); err != nil {
+		return nil, err
 	}
 
-	output := make([]byte, header.Len)
-	for i := uint32(0); i < header.Len; i += chunkSize {
-		// XOR every 4 bytes with the next call to rand().
-		result := binary.LittleEndian.Uint32(input[i:i+chunkSize]) ^ uint32(randFunc())
-		binary.LittleEndian.PutUint32(output[i:], result)
+	header, decrypted, decryptedMetadata, err := Decrypt(encryptedConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypt: %v", ErrVerificationFailed, err)
+	}
+	order, err := metadata.ByteOrder()
+	if err != nil {
+		return nil, err
+	}
+	if crc := Checksum(configBytes, order); header.Crc != crc {
+		return nil, fmt.Errorf("%w: checksum is %#08x, expected %#08x", ErrVerificationFailed, header.Crc, crc)
+	}
+	if !reflect.DeepEqual(decryptedMetadata.canonical(), metadata.canonical()) {
+		return nil, fmt.Errorf("%w: decrypts with metadata %+v, expected %+v", ErrVerificationFailed, *decryptedMetadata, *metadata)
 	}
+	if !bytes.Equal(decrypted, configBytes) {
+		offset := 0
+		for offset < len(decrypted) && offset < len(configBytes) && decrypted[offset] == configBytes[offset] {
+			offset++
+		}
+		return nil, fmt.Errorf("%w: entries differ at offset %d", ErrVerificationFailed, offset)
+	}
+	return encryptedConfig, nil
+}
+
+func xorCipher(header *Header, input []byte, metadata *Metadata) ([]byte, error) {
+	// XOR every word with the next call to rand(), as returned by getKeystream
+	keystream, err := getKeystream(metadata, int(header.Len))
+	if err != nil {
+		return nil, err
+	}
+	output := make([]byte, header.Len)
+	xorBytes(output, input, keystream)
 	return output, nil
 }
 
-func ToJSON(configBytes []byte, metadata *Metadata, raw bool) (wrapperJSON []byte, err error) {
-	w := wrapper{Metadata: metadata}
+// ToJSON wraps a decrypted config and its metadata in JSON.
+// Supported options: WithSource, WithSalvageReport, WithProvenance.
+func ToJSON(configBytes []byte, metadata *Metadata, raw bool, opts ...Option) (wrapperJSON []byte, err error) {
+	o := newOptions(opts)
+	digest, err := metadataDigest(metadata)
+	if err != nil {
+		return nil, err
+	}
+	w := wrapper{Version: WrapperVersion, Metadata: metadata, Integrity: &Integrity{MetadataSHA256: digest}}
+	if o.source != nil {
+		sum := sha256.Sum256(o.source)
+		w.Integrity.SourceSHA256 = hex.EncodeToString(sum[:])
+	}
+	if o.integrity != nil {
+		w.Integrity = o.integrity
+	}
+	w.Salvage = o.salvageReport
 
+	config, parseErr := parseEntries(configBytes)
 	if raw {
 		w.ConfigRaw = configBytes
 	} else {
-		// Use orderedmap to preserve original ordering of entries.
-		config := orderedmap.New[string, string]()
-
-		entries := bytes.Split(configBytes, []byte{0})
-		if len(entries) == 0 {
-			return nil, errors.New("config entries are not separated by null bytes")
+		if parseErr != nil {
+			return nil, parseErr
 		}
+		w.Config = config
+	}
 
-		for _, entry := range entries {
-			if len(entry) == 0 {
-				// The last two bytes of the plaintext are always 0, so there's nothing to split there.
-				continue
-			}
-
-			mapping := bytes.Split(entry, []byte{'='})
-			if len(mapping) != 2 {
-				return nil, errors.New("missing or improper '=' separator in config entry")
-			}
-			key := string(mapping[0])
-			value := string(mapping[1])
-
-			if _, present := config.Get(key); present {
-				return nil, errors.New("config has duplicate key")
-			}
-			config.Set(key, value)
+	// Raw configs don't need to be well-formed, in which case there's nothing to protect.
+	var model *Model
+	if parseErr == nil {
+		w.Protected = protectedValues(config)
+		if o.protected != nil {
+			w.Protected = o.protected
 		}
-		w.Config = config
+		model = IdentifyModel(config)
+		if !o.allowRedacted {
+			w.Redacted = redactedKeys(config, model)
+		}
+	}
+	if o.provenance {
+		w.Provenance = newProvenance(o, metadata, model)
 	}
 
 	b, err := json.Marshal(w)
@@ -221,9 +559,20 @@ func ToJSON(configBytes []byte, metadata *Metadata, raw bool) (wrapperJSON []byt
 	return buf.Bytes(), nil
 }
 
-func FromJSON(wrapperJSON []byte) (configBytes []byte, metadata *Metadata, err error) {
-	w := wrapper{}
-	err = json.Unmarshal(wrapperJSON, &w)
+// FromJSON extracts the decrypted config and metadata from a JSON wrapper.
+// Supported options: NoValidate, AllowProtected, AllowMetadataChanges, WithSecrets, AllowRedacted, Unchecked.
+func FromJSON(wrapperJSON []byte, opts ...Option) (configBytes []byte, metadata *Metadata, err error) {
+	w, configBytes, err := fromJSON(wrapperJSON, newOptions(opts))
+	if w != nil {
+		metadata = w.Metadata
+	}
+	return configBytes, metadata, err
+}
+
+// fromJSON implements FromJSON, returning the whole wrapper. The wrapper may be set even if there's an error.
+func fromJSON(wrapperJSON []byte, o *options) (w *wrapper, configBytes []byte, err error) {
+	w = &wrapper{}
+	err = json.Unmarshal(wrapperJSON, w)
 	if err != nil {
 		return
 	}
@@ -232,7 +581,38 @@ func FromJSON(wrapperJSON []byte) (configBytes []byte, metadata *Metadata, err e
 		err = errors.New("'metadata' is required")
 		return
 	}
-	metadata = w.Metadata
+	if err = migrate(w); err != nil {
+		return nil, nil, err
+	}
+	metadata := w.Metadata
+	if _, err = metadata.ByteOrder(); err != nil {
+		return nil, nil, err
+	}
+	wordSize, err := metadata.wordSize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if w.Salvage != nil && !o.unchecked {
+		err = ErrSalvaged
+		return
+	}
+
+	// Wrappers from older versions of orbicfg don't record a digest
+	if w.Integrity != nil && !o.allowMetadataChanges {
+		var digest string
+		if digest, err = metadataDigest(metadata); err != nil {
+			return nil, nil, err
+		}
+		if digest != w.Integrity.MetadataSHA256 {
+			return nil, nil, ErrMetadataModified
+		}
+	}
+	if !o.unchecked {
+		if err = metadata.checkOrigin(); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	if w.Config == nil && w.ConfigRaw == nil {
 		err = errors.New("'config' or 'config_raw' is required")
@@ -245,44 +625,205 @@ func FromJSON(wrapperJSON []byte) (configBytes []byte, metadata *Metadata, err e
 	}
 
 	if w.Config != nil {
-		for pair := w.Config.Oldest(); pair != nil; pair = pair.Next() {
-			configBytes = append(configBytes, []byte(fmt.Sprintf("%s=%s", pair.Key, pair.Value))...)
-			configBytes = append(configBytes, 0)
-		}
-
-		// XXX: is this really how padding is done?
-		paddingLen := chunkSize - (len(configBytes) % chunkSize)
-		configBytes = append(configBytes, bytes.Repeat([]byte{0}, paddingLen)...)
+		configBytes = padToWordSize(serializeEntries(w.Config), wordSize)
 	} else {
 		configBytes = w.ConfigRaw
 	}
+
+	// Protected values were recorded from the redacted config, so they're checked before rehydrating
+	if w.Protected != nil && !o.unchecked {
+		if err = checkProtected(configBytes, w.Protected, o.allowProtected); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if o.secrets != nil {
+		if configBytes, err = Rehydrate(configBytes, o.secrets, w.Metadata); err != nil {
+			return nil, nil, fmt.Errorf("rehydrate config: %w", err)
+		}
+	}
+	if len(w.Redacted) > 0 && !o.allowRedacted {
+		if err = checkRedacted(configBytes, w.Redacted); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !o.noValidate {
+		if err = Validate(configBytes); err != nil {
+			return nil, nil, fmt.Errorf("validate config: %w", err)
+		}
+	}
 	return
 }
 
-func parseHeader(encryptedConfig []byte) (*Header, error) {
+func metadataDigest(metadata *Metadata) (string, error) {
+	// An origin that can be derived from the header offset isn't part of the digest,
+	// so that wrappers from before it was recorded still match
+	m := *metadata
+	if m.Origin == containerOrigin(m.HeaderOffset) {
+		m.Origin = ""
+	}
+	b, err := json.Marshal(&m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// protectedValues returns the values of the protected keys of the config's model.
+func protectedValues(config *orderedmap.OrderedMap[string, string]) map[string]string {
+	model := IdentifyModel(config)
+	if model == nil {
+		return nil
+	}
+	protected := make(map[string]string)
+	for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+		if model.IsProtected(pair.Key) {
+			protected[pair.Key] = pair.Value
+		}
+	}
+	return protected
+}
+
+// checkProtected verifies that none of the protected keys changed, other than those explicitly allowed.
+func checkProtected(configBytes []byte, protected map[string]string, allowed map[string]bool) error {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return fmt.Errorf("check protected keys: %w", err)
+	}
+
+	// Check in a stable order so the same error is always reported first
+	keys := make([]string, 0, len(protected))
+	for k := range protected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if allowed[k] {
+			continue
+		}
+		original := protected[k]
+		current, ok := config.Get(k)
+		if !ok {
+			return &ProtectedKeyError{Key: k, Original: original, Deleted: true}
+		}
+		if current != original {
+			return &ProtectedKeyError{Key: k, Original: original, Current: current}
+		}
+	}
+	return nil
+}
+
+// parseEntries splits the decrypted config into its key-value pairs.
+func parseEntries(configBytes []byte) (*orderedmap.OrderedMap[string, string], error) {
+	// Use orderedmap to preserve original ordering of entries.
+	config := orderedmap.New[string, string]()
+
+	entries := bytes.Split(configBytes, []byte{0})
+	if len(entries) == 0 {
+		return nil, errors.New("config entries are not separated by null bytes")
+	}
+
+	// Offset of each key, for reporting duplicates
+	offsets := make(map[string]int)
+	offset := 0
+	for _, entry := range entries {
+		entryOffset := offset
+		offset += len(entry) + 1
+		if len(entry) == 0 {
+			// The last two bytes of the plaintext are always 0, so there's nothing to split there.
+			continue
+		}
+
+		mapping := bytes.Split(entry, []byte{'='})
+		if len(mapping) != 2 {
+			e := &EntryError{Offset: entryOffset, Raw: entry}
+			if len(mapping) > 2 {
+				e.Key = string(mapping[0])
+			}
+			return nil, e
+		}
+		key := string(mapping[0])
+		value := string(mapping[1])
+
+		if first, present := offsets[key]; present {
+			return nil, &DuplicateKeyError{Key: key, Offsets: []int{first, entryOffset}}
+		}
+		offsets[key] = entryOffset
+		config.Set(key, value)
+	}
+	return config, nil
+}
+
+// serializeEntries is the inverse of parseEntries.
+func serializeEntries(config *orderedmap.OrderedMap[string, string]) []byte {
+	var configBytes []byte
+	for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+		configBytes = append(configBytes, []byte(fmt.Sprintf("%s=%s", pair.Key, pair.Value))...)
+		configBytes = append(configBytes, 0)
+	}
+
+	// XXX: is this really how padding is done?
+	paddingLen := chunkSize - (len(configBytes) % chunkSize)
+	return append(configBytes, bytes.Repeat([]byte{0}, paddingLen)...)
+}
+
+// padToWordSize appends null bytes until the config is a whole number of words.
+func padToWordSize(configBytes []byte, wordSize int) []byte {
+	if r := len(configBytes) % wordSize; r != 0 {
+		configBytes = append(configBytes, make([]byte, wordSize-r)...)
+	}
+	return configBytes
+}
+
+// locateHeader finds and parses the header of an encrypted config, skipping over any container.
+func locateHeader(encryptedConfig []byte, order binary.ByteOrder) (offset uint64, header *Header, err error) {
+	if bytes.HasPrefix(encryptedConfig, []byte(tarMarker)) {
+		if len(encryptedConfig) <= configOffsetAfterTar {
+			return 0, nil, &HeaderError{Offset: configOffsetAfterTar, Reason: fmt.Sprintf("file starts with %q, but is too small (%v) to hold a config at this offset", tarMarker, len(encryptedConfig))}
+		}
+		offset = configOffsetAfterTar
+	}
+
+	header, err = parseHeader(encryptedConfig[offset:], order)
+	if err != nil {
+		if headerErr, ok := err.(*HeaderError); ok {
+			headerErr.Offset = offset
+		}
+		return 0, nil, err
+	}
+	return offset, header, nil
+}
+
+// parseHeader parses the header at the start of encryptedConfig. Errors are *HeaderError with an offset of 0.
+func parseHeader(encryptedConfig []byte, order binary.ByteOrder) (*Header, error) {
 	if len(encryptedConfig) < headerSize {
-		return nil, fmt.Errorf("config is smaller than header size (%v < %v)", len(encryptedConfig), headerSize)
+		return nil, &HeaderError{Reason: fmt.Sprintf("config is smaller than header size (%v < %v)", len(encryptedConfig), headerSize)}
 	}
 
 	header := &Header{
-		Magic: binary.LittleEndian.Uint32(encryptedConfig[:4]),
-		Len:   binary.LittleEndian.Uint32(encryptedConfig[4:8]),
-		Crc:   binary.LittleEndian.Uint32(encryptedConfig[8:headerSize]),
+		Magic: order.Uint32(encryptedConfig[:4]),
+		Len:   order.Uint32(encryptedConfig[4:8]),
+		Crc:   order.Uint32(encryptedConfig[8:headerSize]),
 	}
 
-	if int(header.Len) != len(encryptedConfig[headerSize:]) {
-		return nil, fmt.Errorf("header length (%v) != length of config data (%v)", header.Len, len(encryptedConfig[headerSize:]))
+	// Any data beyond the stated length is a trailer
+	if uint64(header.Len) > uint64(len(encryptedConfig[headerSize:])) {
+		return nil, &HeaderError{Reason: fmt.Sprintf("header length (%v) > length of config data (%v)", header.Len, len(encryptedConfig[headerSize:]))}
 	}
 	if header.Len%chunkSize != 0 {
-		return nil, fmt.Errorf("header length %v is not divisible by chunk size", header.Len)
+		return nil, &HeaderError{Reason: fmt.Sprintf("header length %v is not divisible by chunk size", header.Len)}
 	}
 	return header, nil
 }
 
-func verifyChecksum(header *Header, configBytes []byte) error {
+// VerifyChecksum checks the checksum in the header against the decrypted config.
+func VerifyChecksum(header *Header, configBytes []byte, order binary.ByteOrder) error {
 	crc := header.Crc
 	for i := 0; i < len(configBytes); i += chunkSize {
-		crc += binary.LittleEndian.Uint32(configBytes[i : i+4])
+		crc += order.Uint32(configBytes[i : i+4])
 	}
 	if crc != initialCrc {
 		return ErrInvalidChecksum
@@ -290,10 +831,11 @@ func verifyChecksum(header *Header, configBytes []byte) error {
 	return nil
 }
 
-func calcChecksum(configBytes []byte) uint32 {
+// Checksum calculates the checksum of a decrypted config, as stored in the header of the encrypted config.
+func Checksum(configBytes []byte, order binary.ByteOrder) uint32 {
 	crc := initialCrc
 	for i := 0; i < len(configBytes); i += chunkSize {
-		crc -= binary.LittleEndian.Uint32(configBytes[i : i+4])
+		crc -= order.Uint32(configBytes[i : i+4])
 	}
 	return crc
 }
@@ -1,15 +1,36 @@
 package cfg
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 
+	"github.com/fysac/orbicfg/rand/musl"
+	"github.com/fysac/orbicfg/rand/uclibc"
 	"github.com/stretchr/testify/assert"
 )
 
-var devices = []string{"RBR50", "RBR760"}
+// Every directory of testdataDir with an encrypted config, named after the device's model.
+// The -BE devices are synthetic: the little-endian configs re-encrypted as big-endian, with 4- and 8-byte words.
+var devices = findDevices()
+
+// Devices that tests use for specific cases
+const (
+	rbr50  = "RBR50"
+	rbr760 = "RBR760"
+)
 
 const (
 	testDataDir                = "testdata"
@@ -25,22 +46,27 @@ func TestDecrypt(t *testing.T) {
 	for _, d := range devices {
 		testDecrypt(t, filepath.Join(testDataDir, d, encryptedConfigFile),
 			filepath.Join(testDataDir, d, decryptedConfigFile),
-			filepath.Join(testDataDir, d, decryptedConfigFileRaw))
+			filepath.Join(testDataDir, d, decryptedConfigFileRaw), false)
 	}
 }
 
 func TestDecryptSoap(t *testing.T) {
-	// Only RBR50 for now
-	// TODO: add SOAP test cases for other devices
-	testDecrypt(t, filepath.Join(testDataDir, devices[0], encryptedConfigFileSoap),
-		filepath.Join(testDataDir, devices[0], decryptedConfigFileSoap),
-		filepath.Join(testDataDir, devices[0], decryptedConfigFileSoapRaw))
+	for _, d := range soapDevices() {
+		testDecrypt(t, filepath.Join(testDataDir, d, encryptedConfigFileSoap),
+			filepath.Join(testDataDir, d, decryptedConfigFileSoap),
+			filepath.Join(testDataDir, d, decryptedConfigFileSoapRaw), true)
+	}
 }
 
 // Tests Decrypt(), FromJSON(), and ToJSON()
-func testDecrypt(t *testing.T, encryptedFile, decryptedFile, decryptedFileRaw string) {
+// The wrappers record origin, which for SOAP downloads is given by the user (decrypt -soap).
+func testDecrypt(t *testing.T, encryptedFile, decryptedFile, decryptedFileRaw string, soap bool) {
 	// Decrypt encrypted config
 	_, configBytes, metadata := decryptFile(t, encryptedFile)
+	if soap {
+		assert.Equal(t, OriginBare, metadata.Origin)
+		metadata.Origin = OriginSoap
+	}
 
 	// Read JSON wrappers for already-decrypted configs
 	expectedWrapperJSON, err := os.ReadFile(decryptedFile)
@@ -115,6 +141,415 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecryptConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		_, expected, _, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, configBytes, _, err := Decrypt(encryptedConfig)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, configBytes)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestDecryptReader(t *testing.T) {
+	var files []string
+	for _, d := range soapDevices() {
+		files = append(files, filepath.Join(testDataDir, d, encryptedConfigFileSoap))
+	}
+	for _, d := range devices {
+		files = append(files, filepath.Join(testDataDir, d, encryptedConfigFile))
+	}
+	for _, f := range files {
+		encryptedConfig, err := os.ReadFile(f)
+		assert.NoError(t, err)
+		header, configBytes, metadata, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+
+		r, err := NewDecryptReader(iotest.OneByteReader(bytes.NewReader(encryptedConfig)))
+		assert.NoError(t, err)
+		assert.Equal(t, header, r.Header)
+		assert.Equal(t, metadata, r.Metadata)
+		decrypted, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, configBytes, decrypted)
+
+		// The checksum is verified at EOF
+		corrupted := make([]byte, len(encryptedConfig))
+		copy(corrupted, encryptedConfig)
+		corrupted[len(corrupted)-1] ^= 0xff
+		r, err = NewDecryptReader(bytes.NewReader(corrupted))
+		assert.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrInvalidChecksum)
+
+		// Truncation is detected
+		r, err = NewDecryptReader(bytes.NewReader(encryptedConfig[:len(encryptedConfig)-100]))
+		assert.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+		// Even right after the header
+		_, err = NewDecryptReader(bytes.NewReader(encryptedConfig[:metadata.HeaderOffset+headerSize+8]))
+		var headerErr *HeaderError
+		assert.ErrorAs(t, err, &headerErr)
+	}
+}
+
+func TestEncryptWriter(t *testing.T) {
+	for _, d := range devices {
+		_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+		expected, err := Encrypt(configBytes, metadata, NoValidate())
+		assert.NoError(t, err)
+
+		// Not seekable, so buffered
+		var buf bytes.Buffer
+		w, err := NewEncryptWriter(&buf, metadata)
+		assert.NoError(t, err)
+		for i := 0; i < len(configBytes); i += 7 {
+			end := i + 7
+			if end > len(configBytes) {
+				end = len(configBytes)
+			}
+			_, err = w.Write(configBytes[i:end])
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Close())
+		assert.Equal(t, expected, buf.Bytes())
+
+		// Seekable, so the header is patched in
+		f, err := os.CreateTemp(t.TempDir(), "encrypted")
+		assert.NoError(t, err)
+		w, err = NewEncryptWriter(f, metadata)
+		assert.NoError(t, err)
+		_, err = io.Copy(w, iotest.HalfReader(bytes.NewReader(configBytes)))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		assert.NoError(t, f.Close())
+		written, err := os.ReadFile(f.Name())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, written)
+
+		w, err = NewEncryptWriter(io.Discard, metadata)
+		assert.NoError(t, err)
+		_, err = w.Write(configBytes[:len(configBytes)-1])
+		assert.NoError(t, err)
+		assert.Error(t, w.Close())
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, d := range devices {
+		c, err := Open(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.Equal(t, configBytes, c.Bytes())
+		assert.Equal(t, metadata, c.Metadata)
+
+		keys := c.Keys()
+		assert.Len(t, keys, c.Len())
+		first, ok := c.Get(keys[0])
+		assert.True(t, ok)
+		c.Range(func(key, value string) bool {
+			assert.Equal(t, keys[0], key)
+			assert.Equal(t, first, value)
+			return false
+		})
+
+		assert.NoError(t, c.Set("my-new-key", "foobar"))
+		assert.Equal(t, "my-new-key", c.Keys()[c.Len()-1])
+		assert.Equal(t, []string{"my-new-key"}, c.Prefix("my-new"))
+		assert.True(t, c.Delete(keys[0]))
+		assert.False(t, c.Delete(keys[0]))
+		_, ok = c.Get(keys[0])
+		assert.False(t, ok)
+		assert.NoError(t, c.Set(keys[0], first))
+
+		// Entries that couldn't be parsed back are refused
+		for _, kv := range [][2]string{{"", "x"}, {"a=b", "x"}, {"a\x00b", "x"}, {"my-new-key", "x\x00y=z"}} {
+			assert.Error(t, c.Set(kv[0], kv[1]), kv[0])
+		}
+		_, err = parseEntries(c.Bytes())
+		assert.NoError(t, err)
+
+		// Round trip through a wrapper and an encrypted config
+		dir := t.TempDir()
+		assert.NoError(t, c.Save(filepath.Join(dir, "decrypted.json")))
+		assert.NoError(t, c.Save(filepath.Join(dir, "encrypted.cfg")))
+		for _, name := range []string{"decrypted.json", "encrypted.cfg"} {
+			reopened, err := Open(filepath.Join(dir, name))
+			assert.NoError(t, err)
+			assert.Equal(t, c.Bytes(), reopened.Bytes())
+			value, _ := reopened.Get("my-new-key")
+			assert.Equal(t, "foobar", value)
+		}
+	}
+}
+
+func TestConfigSaveChecks(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(filepath.Join(testDataDir, rbr760, decryptedConfigFile))
+	assert.NoError(t, err)
+	region, _ := c.Get("dgc.project.board_data.region")
+	assert.NoError(t, c.Set("dgc.project.board_data.region", region+"X"))
+
+	var protectedErr *ProtectedKeyError
+	for _, name := range []string{"x.cfg", "x.json"} {
+		err = c.Save(filepath.Join(dir, name))
+		if assert.ErrorAs(t, err, &protectedErr, name) {
+			assert.Equal(t, "dgc.project.board_data.region", protectedErr.Key)
+		}
+		assert.NoFileExists(t, filepath.Join(dir, name))
+	}
+
+	// The wrapper keeps the original value, so the change is still caught when it's read
+	assert.NoError(t, c.Save(filepath.Join(dir, "x.json"), AllowProtected("dgc.project.board_data.region")))
+	wrapperJSON, err := os.ReadFile(filepath.Join(dir, "x.json"))
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.ErrorAs(t, err, &protectedErr)
+	_, _, err = FromJSON(wrapperJSON, AllowProtected("dgc.project.board_data.region"))
+	assert.NoError(t, err)
+
+	// Save never overwrites a file
+	err = c.Save(filepath.Join(dir, "x.json"), AllowProtected("dgc.project.board_data.region"))
+	assert.ErrorIs(t, err, iofs.ErrExist)
+
+	c, err = Open(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.NoError(t, err)
+	c.Metadata.RealMagic++
+	assert.ErrorIs(t, c.Save(filepath.Join(dir, "y.cfg")), ErrMetadataModified)
+}
+
+func TestEncryptVerified(t *testing.T) {
+	for _, d := range devices {
+		wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, d, decryptedConfigFile))
+		assert.NoError(t, err)
+		configBytes, metadata, err := FromJSON(wrapperJSON)
+		assert.NoError(t, err)
+
+		encryptedConfig, err := EncryptVerified(configBytes, metadata)
+		assert.NoError(t, err)
+		expected, err := Encrypt(configBytes, metadata)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, encryptedConfig)
+	}
+
+	// A stated magic that resolves to an override other than the one used for encryption
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFile))
+	assert.NoError(t, err)
+	configBytes, metadata, err := FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+	metadata.StatedMagic = 0x01346231
+	_, err = EncryptVerified(configBytes, metadata)
+	assert.ErrorIs(t, err, ErrVerificationFailed)
+}
+
+func TestFixChecksum(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		header, _, metadata, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+
+		// Make the checksum invalid
+		corrupted := make([]byte, len(encryptedConfig))
+		copy(corrupted, encryptedConfig)
+		order, err := metadata.ByteOrder()
+		assert.NoError(t, err)
+		order.PutUint32(corrupted[metadata.HeaderOffset+8:], 0xeeeeeeee)
+		_, _, _, err = Decrypt(corrupted)
+		assert.ErrorIs(t, err, ErrInvalidChecksum)
+
+		fixed, report, err := FixChecksum(corrupted)
+		assert.NoError(t, err)
+		assert.Equal(t, encryptedConfig, fixed)
+		assert.Equal(t, &ChecksumReport{OldChecksum: 0xeeeeeeee, NewChecksum: header.Crc, Rng: metadata.Rng, Endian: metadata.Endian, WordSize: metadata.WordSize, WellFormed: true}, report)
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		_, configBytes, metadata, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+
+		// Undamaged configs are recovered completely
+		salvaged, salvagedMetadata, report, err := Salvage(encryptedConfig)
+		assert.NoError(t, err)
+		assert.Equal(t, configBytes, salvaged)
+		assert.Equal(t, metadata, salvagedMetadata)
+		assert.True(t, report.ChecksumValid)
+		assert.Empty(t, report.Corrupted)
+
+		// Turn the first byte of the 100th entry into a control character and truncate the config
+		damaged := make([]byte, len(encryptedConfig)-1000)
+		copy(damaged, encryptedConfig)
+		entryOffset := 0
+		for i := 0; i < 100; i++ {
+			entryOffset += bytes.IndexByte(configBytes[entryOffset:], 0) + 1
+		}
+		damaged[int(metadata.HeaderOffset)+headerSize+entryOffset] ^= configBytes[entryOffset] ^ 0x01
+
+		salvaged, _, report, err = Salvage(damaged)
+		assert.NoError(t, err)
+		assert.False(t, report.ChecksumValid)
+		assert.Equal(t, uint32(len(configBytes)-1000), report.AvailableLen)
+		assert.Equal(t, CorruptRange{Offset: entryOffset, Len: bytes.IndexByte(configBytes[entryOffset:], 0), Reason: "non-printable bytes"}, report.Corrupted[0])
+
+		config, err := parseEntries(salvaged)
+		assert.NoError(t, err)
+		assert.Equal(t, report.RecoveredEntries, config.Len())
+
+		// Salvaged wrappers must be reviewed before they're encrypted
+		wrapperJSON, err := ToJSON(salvaged, metadata, false, WithSalvageReport(report))
+		assert.NoError(t, err)
+		_, _, err = FromJSON(wrapperJSON)
+		assert.ErrorIs(t, err, ErrSalvaged)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+
+		result := Preflight(encryptedConfig, LookupModel(deviceModel(d)))
+		assert.True(t, result.Passed, "%+v", result.Checks)
+		assert.Equal(t, deviceModel(d), result.Model)
+	}
+
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.NoError(t, err)
+	result := Preflight(encryptedConfig, LookupModel(rbr760))
+	assert.False(t, result.Passed)
+	assert.Equal(t, PreflightCheck{Name: "model", Reason: "config is for RBR50, not RBR760"}, result.Checks[len(result.Checks)-1])
+
+	// Appended data is tolerated by Decrypt, but not by the device
+	withTrailer := append(append([]byte{}, encryptedConfig...), make([]byte, 24)...)
+	result = Preflight(withTrailer, nil)
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Checks, PreflightCheck{Name: "trailer", Reason: "24 bytes of trailing data after the config"})
+
+	// Corrupt the checksum
+	encryptedConfig[configOffsetAfterTar+8] ^= 0xff
+	result = Preflight(encryptedConfig, nil)
+	assert.False(t, result.Passed)
+	assert.Equal(t, PreflightCheck{Name: "checksum", Reason: ErrInvalidChecksum.Error()}, result.Checks[len(result.Checks)-1])
+}
+
+func TestTrailer(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+
+		for _, trailer := range [][]byte{
+			[]byte("-----BEGIN SIGNATURE-----"),
+			make([]byte, maxTrailerData+1),
+		} {
+			withTrailer := append(append([]byte{}, encryptedConfig...), trailer...)
+			_, configBytes, metadata, err := Decrypt(withTrailer)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(len(trailer)), metadata.Trailer.Len)
+
+			// The trailer survives a round trip through the JSON wrapper
+			wrapperJSON, err := ToJSON(configBytes, metadata, false)
+			assert.NoError(t, err)
+			configBytes, metadata, err = FromJSON(wrapperJSON)
+			assert.NoError(t, err)
+			reencrypted, err := EncryptVerified(configBytes, metadata)
+			assert.NoError(t, err)
+			// The tar container isn't preserved, so only compare from the header onwards
+			assert.True(t, bytes.Equal(withTrailer[metadata.HeaderOffset:], reencrypted[metadata.HeaderOffset:]))
+		}
+
+		// Large trailers that aren't padding can't be recreated
+		withTrailer := append(append([]byte{}, encryptedConfig...), bytes.Repeat([]byte{1}, maxTrailerData+1)...)
+		_, configBytes, metadata, err := Decrypt(withTrailer)
+		assert.NoError(t, err)
+		assert.Nil(t, metadata.Trailer.Data)
+		_, err = Encrypt(configBytes, metadata)
+		assert.Error(t, err)
+
+		// Overrides are unaffected
+		for _, o := range Overrides() {
+			assert.Nil(t, o.Trailer)
+		}
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	_, soapConfig, soapMetadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFileSoap))
+	assert.Equal(t, OriginBare, soapMetadata.Origin)
+	_, webConfig, webMetadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.Equal(t, OriginWeb, webMetadata.Origin)
+
+	// A SOAP download can be restored through the web interface, and vice versa
+	encryptedConfig, metadata := encryptRoundTrip(t, soapConfig, soapMetadata, WithOrigin(OriginWeb))
+	assert.True(t, bytes.HasPrefix(encryptedConfig, []byte(tarMarker)))
+	assert.Equal(t, OriginWeb, metadata.Origin)
+	assert.Equal(t, uint64(configOffsetAfterTar), metadata.HeaderOffset)
+
+	encryptedConfig, metadata = encryptRoundTrip(t, webConfig, webMetadata, WithOrigin(OriginSoap))
+	assert.False(t, bytes.HasPrefix(encryptedConfig, []byte(tarMarker)))
+	assert.Equal(t, OriginBare, metadata.Origin)
+
+	_, err := Encrypt(webConfig, webMetadata, WithOrigin("ftp"))
+	assert.Error(t, err)
+
+	// Devices with an override are found inside a container too
+	_, overrideConfig, overrideMetadata := decryptFile(t, filepath.Join(testDataDir, rbr760, encryptedConfigFile))
+	encryptedConfig, metadata = encryptRoundTrip(t, overrideConfig, overrideMetadata, WithOrigin(OriginWeb))
+	assert.Equal(t, OriginWeb, metadata.Origin)
+	assert.Equal(t, uint64(configOffsetAfterTar), metadata.HeaderOffset)
+	assert.Equal(t, overrideMetadata.RealMagic, metadata.RealMagic)
+	d, err := NewDecryptReader(bytes.NewReader(encryptedConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, metadata, d.Metadata)
+	streamed, err := io.ReadAll(d)
+	assert.NoError(t, err)
+	assert.Equal(t, overrideConfig, streamed)
+	for _, o := range Overrides() {
+		assert.Zero(t, o.HeaderOffset)
+	}
+
+	// Wrappers from before the origin was recorded still match their digest
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFile))
+	assert.NoError(t, err)
+	legacyJSON := bytes.Replace(wrapperJSON, []byte(",\n        \"origin\": \"web\""), nil, 1)
+	legacyJSON = bytes.Replace(legacyJSON, []byte("\n    \"version\": 2,"), nil, 1)
+	assert.Len(t, legacyJSON, len(wrapperJSON)-len(",\n        \"origin\": \"web\"")-len("\n    \"version\": 2,"))
+	_, metadata, err = FromJSON(legacyJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, webMetadata, metadata)
+
+	// The user may record that a bare config was downloaded over SOAP
+	soapMetadata.Origin = OriginSoap
+	wrapperJSON, err = ToJSON(soapConfig, soapMetadata, false)
+	assert.NoError(t, err)
+	_, metadata, err = FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, OriginSoap, metadata.Origin)
+	encryptRoundTrip(t, soapConfig, metadata)
+
+	metadata.Origin = OriginWeb
+	wrapperJSON, err = ToJSON(soapConfig, metadata, false)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.ErrorContains(t, err, "doesn't match header offset")
+}
+
 func TestChecksum(t *testing.T) {
 	for _, d := range devices {
 		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
@@ -125,27 +560,616 @@ func TestChecksum(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Verify that we produce the same checksum
-		assert.Equal(t, header.Crc, calcChecksum(configBytes))
+		order, err := metadata.ByteOrder()
+		assert.NoError(t, err)
+		assert.Equal(t, header.Crc, Checksum(configBytes, order))
 
 		// Make the checksum invalid and try to decrypt
 		oldChecksum := header.Crc
 		header.Crc = 0xeeeeeeee
-		copy(encryptedConfig[metadata.HeaderOffset:metadata.HeaderOffset+headerSize], header.Bytes())
+		copy(encryptedConfig[metadata.HeaderOffset:metadata.HeaderOffset+headerSize], header.BytesOrder(order))
 		_, _, _, err = Decrypt(encryptedConfig)
 		assert.EqualError(t, err, ErrInvalidChecksum.Error())
 
 		// Restore the proper checksum
 		header.Crc = oldChecksum
-		copy(encryptedConfig[metadata.HeaderOffset:metadata.HeaderOffset+headerSize], header.Bytes())
+		copy(encryptedConfig[metadata.HeaderOffset:metadata.HeaderOffset+headerSize], header.BytesOrder(order))
 		_, _, _, err = Decrypt(encryptedConfig)
 		assert.NoError(t, err)
+
+		// Bytes is little-endian, as it always was
+		if metadata.Endian != EndianBig {
+			assert.Equal(t, encryptedConfig[metadata.HeaderOffset:metadata.HeaderOffset+headerSize], header.Bytes())
+		}
+	}
+}
+
+func TestCipherVariants(t *testing.T) {
+	_, _, metadata := decryptFile(t, filepath.Join(testDataDir, "RBR50-BE", encryptedConfigFile))
+	assert.Equal(t, EndianBig, metadata.Endian)
+	assert.Equal(t, 0, metadata.WordSize)
+	_, _, metadata = decryptFile(t, filepath.Join(testDataDir, "RBR760-BE64", encryptedConfigFile))
+	assert.Equal(t, EndianBig, metadata.Endian)
+	assert.Equal(t, 8, metadata.WordSize)
+
+	// Explicit defaults are equivalent to omitted ones
+	_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	explicit := *metadata
+	explicit.Endian, explicit.WordSize = EndianLittle, 4
+	encryptRoundTrip(t, configBytes, &explicit)
+
+	unsupported := *metadata
+	unsupported.Endian = "middle"
+	_, err := Encrypt(configBytes, &unsupported)
+	assert.Error(t, err)
+	unsupported = *metadata
+	unsupported.WordSize = 2
+	_, err = Encrypt(configBytes, &unsupported)
+	assert.Error(t, err)
+	unsupported = *metadata
+	unsupported.Rng = "glibc"
+	_, err = Encrypt(configBytes, &unsupported)
+	assert.Error(t, err)
+}
+
+func TestErrors(t *testing.T) {
+	_, err := parseEntries([]byte("a=1\x00bad\x00"))
+	var entryErr *EntryError
+	assert.ErrorAs(t, err, &entryErr)
+	assert.Equal(t, &EntryError{Offset: 4, Raw: []byte("bad")}, entryErr)
+
+	_, err = parseEntries([]byte("a=1\x00b=2=3\x00"))
+	assert.ErrorAs(t, err, &entryErr)
+	assert.Equal(t, &EntryError{Offset: 4, Key: "b", Raw: []byte("b=2=3")}, entryErr)
+
+	_, err = parseEntries([]byte("a=1\x00b=2\x00a=3\x00"))
+	var dupErr *DuplicateKeyError
+	assert.ErrorAs(t, err, &dupErr)
+	assert.Equal(t, &DuplicateKeyError{Key: "a", Offsets: []int{0, 8}}, dupErr)
+
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.NoError(t, err)
+	_, _, metadata, err := Decrypt(encryptedConfig[:configOffsetAfterTar+headerSize-1])
+	assert.Nil(t, metadata)
+	var headerErr *HeaderError
+	assert.ErrorAs(t, err, &headerErr)
+	assert.Equal(t, uint64(configOffsetAfterTar), headerErr.Offset)
+
+	_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	metadata.Rng = "glibc"
+	_, err = Encrypt(configBytes, metadata)
+	var rngErr *UnsupportedRNGError
+	assert.ErrorAs(t, err, &rngErr)
+	assert.Equal(t, "glibc", rngErr.Rng)
+}
+
+func TestTracer(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		var trace bytes.Buffer
+		header, configBytes, metadata, err := Decrypt(encryptedConfig, WithTracer(log.New(&trace, "", 0)))
+		assert.NoError(t, err)
+
+		assert.Contains(t, trace.String(), fmt.Sprintf("magic %#08x, len %v, crc %#08x", header.Magic, header.Len, header.Crc))
+		assert.Contains(t, trace.String(), "residue 0x00000000")
+		assert.Contains(t, trace.String(), "decrypted with rng "+metadata.Rng)
+
+		// No value from the dumped part of the config may appear in the trace
+		entries, err := parseEntries(configBytes[:bytes.LastIndexByte(configBytes[:traceDumpLen], 0)+1])
+		assert.NoError(t, err)
+		for pair := entries.Oldest(); pair != nil; pair = pair.Next() {
+			if len(pair.Value) >= 4 {
+				assert.NotContains(t, trace.String(), pair.Value, d)
+			}
+		}
+	}
+
+	assert.Equal(t, []string{
+		"00000000  61 62 3d ** ** 00 63 64  3d 00                    |ab=**.cd=.|",
+	}, redactedDump([]byte("ab=12\x00cd=\x00")))
+}
+
+func TestRedact(t *testing.T) {
+	for _, d := range devices {
+		_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+
+		redacted, secrets, err := Redact(configBytes)
+		assert.NoError(t, err)
+		assert.Len(t, redacted, len(configBytes))
+		assert.NotEmpty(t, secrets)
+
+		config, err := parseEntries(redacted)
+		assert.NoError(t, err)
+		for key, value := range secrets {
+			redactedValue, _ := config.Get(key)
+			assert.Equal(t, placeholder(value), redactedValue)
+			// Fixtures created by the fixture command are already redacted
+			if !IsRedacted(value) {
+				assert.False(t, bytes.Contains(redacted, []byte(key+"="+value+"\x00")))
+			}
+		}
+
+		rehydrated, err := Rehydrate(redacted, secrets, metadata)
+		assert.NoError(t, err)
+		assert.Equal(t, configBytes, rehydrated)
+
+		// Secrets of deleted entries are skipped, and the config is padded to the word size of the metadata
+		// even if the edited config isn't
+		var keys []string
+		for key := range secrets {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		config.Delete(keys[0])
+		rehydrated, err = Rehydrate(serializeEntries(config), secrets, metadata)
+		assert.NoError(t, err)
+		wordSize, err := metadata.wordSize()
+		assert.NoError(t, err)
+		assert.Zero(t, len(rehydrated)%wordSize)
+		config, err = parseEntries(rehydrated)
+		assert.NoError(t, err)
+		_, ok := config.Get(keys[0])
+		assert.False(t, ok)
+		for _, key := range keys[1:] {
+			value, _ := config.Get(key)
+			assert.Equal(t, secrets[key], value)
+		}
+	}
+}
+
+func TestIsSecretKey(t *testing.T) {
+	for _, key := range []string{
+		"http_passwd", "http_passwd_hashed", "wl_wpa2_psk", "wla_2nd_ap_bh_wpa2_psk", "sysDNSPassword_tmp",
+		"wl_radiusSecret", "wl_radius_key", "wl_key1", "wl_wep_64_key1", "PWD_answer1",
+		"wireless.fh_ap_5g.password", "so.gui.password.answer1", "so.ddns.mynetgear.client_key", "lan.rip.key_string",
+	} {
+		assert.True(t, IsSecretKey(key, nil), key)
+	}
+
+	// Settings that a bug report needs
+	for _, key := range []string{
+		"have_set_passwd", "enable_password_recovery", "weak_password_check", "flag_use_passwd_digest",
+		"flag_use_passwd_digest_new", "wl_key", "wla_key", "wla1_key", "wlg1_key", "wlg_arlo_key", "wl_key_length",
+		"wl_wpa_gtk_rekey", "block_KeyWord_DomainList", "so.gui.password.reset_enable", "so.gui.password.is_weak",
+	} {
+		assert.False(t, IsSecretKey(key, nil), key)
+	}
+
+	_, configBytes, _ := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	redacted, _, err := Redact(configBytes)
+	assert.NoError(t, err)
+	for _, entry := range []string{"have_set_passwd=1", "enable_password_recovery=1", "weak_password_check=0", "wl_key=1", "wla1_key=1"} {
+		assert.True(t, bytes.Contains(redacted, []byte("\x00"+entry+"\x00")), entry)
+	}
+}
+
+func TestRedactedWrapper(t *testing.T) {
+	_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	redacted, secrets, err := Redact(configBytes)
+	assert.NoError(t, err)
+
+	wrapperJSON, err := ToJSON(redacted, metadata, false)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	var redactedErr *RedactedError
+	if assert.ErrorAs(t, err, &redactedErr) {
+		assert.Contains(t, redactedErr.Keys, "wl_wpa2_psk")
+		assert.Len(t, redactedErr.Keys, len(secrets))
+	}
+
+	rehydrated, _, err := FromJSON(wrapperJSON, WithSecrets(secrets))
+	assert.NoError(t, err)
+	assert.Equal(t, configBytes, rehydrated)
+
+	// Secrets missing from the secrets file still hold placeholders
+	partial := make(map[string]string)
+	for k, v := range secrets {
+		if k != "wl_wpa2_psk" {
+			partial[k] = v
+		}
+	}
+	_, _, err = FromJSON(wrapperJSON, WithSecrets(partial))
+	if assert.ErrorAs(t, err, &redactedErr) {
+		assert.Equal(t, []string{"wl_wpa2_psk"}, redactedErr.Keys)
+	}
+
+	_, _, err = FromJSON(wrapperJSON, NoValidate(), AllowRedacted())
+	assert.NoError(t, err)
+
+	unmarked, err := ToJSON(redacted, metadata, false, AllowRedacted())
+	assert.NoError(t, err)
+	assert.NotContains(t, string(unmarked), `"redacted"`)
+}
+
+func TestMaskValues(t *testing.T) {
+	assert.Equal(t, []byte("a=***\x00b=\x00c=***\x00d"), MaskValues([]byte("a=123\x00b=\x00c=4=5\x00d")))
+
+	for _, d := range devices {
+		_, configBytes, _ := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+		masked := MaskValues(configBytes)
+		assert.Len(t, masked, len(configBytes))
+
+		config, err := parseEntries(masked)
+		assert.NoError(t, err)
+		c, err := NewConfig(configBytes, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Keys(), (&Config{entries: config}).Keys())
+		c.Range(func(key, value string) bool {
+			maskedValue, _ := config.Get(key)
+			assert.Equal(t, placeholder(value), maskedValue)
+			return true
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	// Keyed by device, since the fixtures of contributed devices are redacted
+	expected := map[string]Credential{
+		"RBR50":       {Service: "Wi-Fi 2.4 GHz (WPA2)", Username: "ORBI10", Secret: "unusualsocks948"},
+		"RBR50-BE":    {Service: "Wi-Fi 2.4 GHz (WPA2)", Username: "ORBI10", Secret: "unusualsocks948"},
+		"RBR760":      {Service: "Guest Wi-Fi 5 GHz", Username: "NETGEAR-Guest", Secret: "Password123"},
+		"RBR760-BE64": {Service: "Guest Wi-Fi 5 GHz", Username: "NETGEAR-Guest", Secret: "Password123"},
+	}
+	for _, d := range devices {
+		_, configBytes, _ := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+
+		creds, err := Credentials(configBytes, false)
+		assert.NoError(t, err)
+		nonEmpty, err := Credentials(configBytes, true)
+		assert.NoError(t, err)
+		if e, ok := expected[d]; ok {
+			assert.Contains(t, creds, e)
+			assert.Contains(t, nonEmpty, e)
+		}
+		assert.Less(t, len(nonEmpty), len(creds))
+		for _, c := range nonEmpty {
+			assert.NotEmpty(t, c.Secret)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	_, configBytes, _ := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+
+	findings, err := Audit(configBytes)
+	assert.NoError(t, err)
+	assert.Contains(t, findings, Finding{Rule: "wps", Severity: SeverityMedium, Key: "endis_wl_wps", Value: "1", Message: "WPS is enabled"})
+	for _, f := range findings {
+		assert.NotEqual(t, "remote-management", f.Rule)
+	}
+
+	// Enable remote management and clear a passphrase
+	config, err := parseEntries(configBytes)
+	assert.NoError(t, err)
+	config.Set("remote_endis", "1")
+	config.Set("wl_wpa2_psk", "")
+
+	findings, err = Audit(serializeEntries(config))
+	assert.NoError(t, err)
+	assert.Equal(t, "remote-management", findings[0].Rule)
+	assert.Equal(t, SeverityHigh, findings[0].Severity)
+	// remote_access is 2 in the fixture
+	assert.Contains(t, findings, Finding{Rule: "remote-management-anyone", Severity: SeverityHigh, Key: "remote_access", Value: "2", Message: "Remote management is open to every address"})
+	assert.Contains(t, findings, Finding{Rule: "empty-passphrase", Severity: SeverityHigh, Key: "wl_wpa2_psk", Message: "A secured Wi-Fi network has an empty passphrase"})
+
+	// A leftover WEP key doesn't matter unless the network uses WEP
+	config.Set("wl_wep_64_key1", "abcde")
+	findings, err = Audit(serializeEntries(config))
+	assert.NoError(t, err)
+	for _, f := range findings {
+		assert.NotEqual(t, "wep", f.Rule)
 	}
+	config.Set("wl_sectype", flatSecTypeWEP)
+	findings, err = Audit(serializeEntries(config))
+	assert.NoError(t, err)
+	assert.Contains(t, findings, Finding{Rule: "wep", Severity: SeverityHigh, Key: "wl_sectype", Value: "2", Message: "A Wi-Fi network uses WEP"})
+}
+
+func TestPolicy(t *testing.T) {
+	_, configBytes, _ := decryptFile(t, filepath.Join(testDataDir, rbr760, encryptedConfigFile))
+
+	policy, err := ParsePolicy([]byte(`
+rules:
+  - name: guest-isolation
+    keys: ["wireless.guest_ap_2g.isolate_enable", "wireless.guest_ap_5g.isolate_enable"]
+    enum: ["1"]
+  - name: lan-subnet
+    key: lan.global.ip_addr
+    cidr: 10.20.30.0/24
+    message: LAN must be 10.20.30.0/24
+  - name: mtu
+    key: wan.*.mtu
+    range: {min: 1400, max: 1500}
+  - name: missing
+    key: no.such.key
+    regex: .*
+  - name: optional
+    key: no.such.key
+    regex: .*
+    optional: true
+  - name: guest-password
+    key: wireless.guest_ap_2g.password
+    regex: ^.{16,}$
+`))
+	assert.NoError(t, err)
+
+	violations, err := policy.Check(configBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, []Violation{
+		{Rule: "lan-subnet", Key: "lan.global.ip_addr", Value: "192.168.1.1", Expected: "in 10.20.30.0/24", Message: "LAN must be 10.20.30.0/24"},
+		{Rule: "missing", Key: "no.such.key", Expected: "key to be present"},
+		{Rule: "guest-password", Key: "wireless.guest_ap_2g.password", Value: placeholder("Password123"), Expected: "matches /^.{16,}$/"},
+	}, violations)
+
+	// No violations is an empty list, not null, in JSON
+	policy, err = ParsePolicy([]byte("rules:\n  - key: lan.global.ip_addr\n    regex: .*\n"))
+	assert.NoError(t, err)
+	violations, err = policy.Check(configBytes)
+	assert.NoError(t, err)
+	assert.NotNil(t, violations)
+	assert.Empty(t, violations)
+
+	_, err = ParsePolicy([]byte("rules:\n  - key: foo\n"))
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	for _, d := range devices {
+		_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, Validate(configBytes))
+
+		config, err := parseEntries(configBytes)
+		assert.NoError(t, err)
+		invalid := map[string]string{
+			"RBR50":  "lan_ipaddr",
+			"RBR760": "lan.global.ip_addr",
+		}
+		invalidKey, ok := invalid[deviceModel(d)]
+		if !ok {
+			invalidKey = "lan_ipaddr"
+		}
+		config.Set(invalidKey, "192.168.1.300")
+		config.Set("my_ssid", "this SSID is much too long to be valid")
+		config.Set("my_wpa2_psk", "short")
+		config.Set("endis_my_feature", "yes")
+		config.Set("my_port", "65536")
+		config.Set("my_block_time", "25:00")
+		wordSize, err := metadata.wordSize()
+		assert.NoError(t, err)
+		configBytes = padToWordSize(serializeEntries(config), wordSize)
+
+		err = Validate(configBytes)
+		var errs ValidationErrors
+		assert.ErrorAs(t, err, &errs)
+		var keys []string
+		for _, e := range errs {
+			keys = append(keys, e.Key)
+		}
+		assert.ElementsMatch(t, []string{invalidKey, "my_ssid", "my_wpa2_psk", "endis_my_feature", "my_port", "my_block_time"}, keys)
+
+		// Secrets aren't leaked in errors
+		assert.NotContains(t, err.Error(), "short")
+
+		_, err = Encrypt(configBytes, metadata)
+		assert.ErrorAs(t, err, &errs)
+		_, err = Encrypt(configBytes, metadata, NoValidate())
+		assert.NoError(t, err)
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+
+	// Raw configs don't need to be well-formed, so a duplicate key isn't a validation error
+	malformed := padToWordSize(append(bytes.TrimRight(configBytes, "\x00"), []byte("\x00lan_ipaddr=192.168.1.1\x00\x00")...), 4)
+	assert.NoError(t, Validate(malformed))
+
+	wrapperJSON, err := ToJSON(malformed, metadata, true)
+	assert.NoError(t, err)
+	roundTripped, metadata, err := FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, malformed, roundTripped)
+	encryptRoundTrip(t, roundTripped, metadata)
+
+	// Entries that do parse are still validated
+	invalid := append(bytes.TrimRight(malformed, "\x00"), []byte("\x00my_port=65536\x00\x00")...)
+	var errs ValidationErrors
+	assert.ErrorAs(t, Validate(padToWordSize(invalid, 4)), &errs)
+	assert.Equal(t, "my_port", errs[0].Key)
+}
+
+func TestProtectedKeys(t *testing.T) {
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr760, decryptedConfigFile))
+	assert.NoError(t, err)
+
+	w := wrapper{}
+	assert.NoError(t, json.Unmarshal(wrapperJSON, &w))
+	assert.Equal(t, "34:98:b5:a3:cf:bd", w.Protected["dgc.project.board_data.radio0_mac_addr"])
+
+	// Unprotected keys can be changed freely
+	w.Config.Set("system.config.device_name", "my-router")
+	wrapperJSON, err = json.Marshal(w)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+
+	// Protected keys can only be changed if explicitly allowed
+	w.Config.Set("dgc.project.board_data.radio0_mac_addr", "34:98:b5:00:00:01")
+	wrapperJSON, err = json.Marshal(w)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	var protectedErr *ProtectedKeyError
+	assert.ErrorAs(t, err, &protectedErr)
+	assert.Equal(t, "dgc.project.board_data.radio0_mac_addr", protectedErr.Key)
+	_, _, err = FromJSON(wrapperJSON, AllowProtected("dgc.project.board_data.radio0_mac_addr"))
+	assert.NoError(t, err)
+
+	w.Config.Delete("dgc.project.board_data.region")
+	wrapperJSON, err = json.Marshal(w)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON, AllowProtected("dgc.project.board_data.radio0_mac_addr"))
+	assert.ErrorAs(t, err, &protectedErr)
+	assert.True(t, protectedErr.Deleted)
+}
+
+func TestMetadataIntegrity(t *testing.T) {
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.NoError(t, err)
+	_, configBytes, metadata, err := Decrypt(encryptedConfig)
+	assert.NoError(t, err)
+
+	wrapperJSON, err := ToJSON(configBytes, metadata, false, WithSource(encryptedConfig))
+	assert.NoError(t, err)
+	w := wrapper{}
+	assert.NoError(t, json.Unmarshal(wrapperJSON, &w))
+	assert.Len(t, w.Integrity.SourceSHA256, 64)
+
+	_, _, err = FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+
+	w.Metadata.Rng = RngMusl
+	wrapperJSON, err = json.Marshal(w)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.ErrorIs(t, err, ErrMetadataModified)
+	_, m, err := FromJSON(wrapperJSON, AllowMetadataChanges())
+	assert.NoError(t, err)
+	assert.Equal(t, RngMusl, m.Rng)
+
+	// Wrappers without a digest are accepted as-is
+	w.Integrity = nil
+	wrapperJSON, err = json.Marshal(w)
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+}
+
+func TestProvenance(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		_, configBytes, metadata, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+
+		wrapperJSON, err := ToJSON(configBytes, metadata, false, WithSource(encryptedConfig), WithProvenance("backup.cfg"))
+		assert.NoError(t, err)
+		p, err := ReadProvenance(wrapperJSON)
+		assert.NoError(t, err)
+		assert.Equal(t, "backup.cfg", p.SourceName)
+		integrity, err := ReadIntegrity(wrapperJSON)
+		assert.NoError(t, err)
+		assert.Len(t, integrity.SourceSHA256, 64)
+		assert.Equal(t, deviceModel(d), p.Model)
+		assert.Equal(t, Version(), p.Version)
+		assert.False(t, p.Decrypted.IsZero())
+		assert.Equal(t, metadata.Origin, p.ExportType)
+
+		// Provenance doesn't affect the config or metadata
+		wrappedConfig, wrappedMetadata, err := FromJSON(wrapperJSON)
+		assert.NoError(t, err)
+		assert.Equal(t, configBytes, wrappedConfig)
+		assert.Equal(t, metadata, wrappedMetadata)
+	}
+
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFile))
+	assert.NoError(t, err)
+	p, err := ReadProvenance(wrapperJSON)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestMigrate(t *testing.T) {
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr760, decryptedConfigFile))
+	assert.NoError(t, err)
+	configBytes, metadata, err := FromJSON(wrapperJSON)
+	assert.NoError(t, err)
+	versionField := []byte(fmt.Sprintf("\n    \"version\": %v,", WrapperVersion))
+	assert.True(t, bytes.Contains(wrapperJSON, versionField))
+
+	// Version 1 had no version field and no origin
+	v1JSON := bytes.Replace(wrapperJSON, versionField, nil, 1)
+	v1JSON = bytes.Replace(v1JSON, []byte(",\n        \"origin\": \"bare\""), nil, 1)
+	assert.NotContains(t, string(v1JSON), "origin")
+	migratedConfig, migratedMetadata, err := FromJSON(v1JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, configBytes, migratedConfig)
+	assert.Equal(t, metadata, migratedMetadata)
+
+	newerJSON := bytes.Replace(wrapperJSON, versionField, []byte(fmt.Sprintf("\n    \"version\": %v,", WrapperVersion+1)), 1)
+	_, _, err = FromJSON(newerJSON)
+	assert.ErrorContains(t, err, "please upgrade orbicfg")
+}
+
+type schemaProperty struct {
+	Description string                     `json:"description"`
+	Enum        []interface{}              `json:"enum"`
+	Maximum     *int                       `json:"maximum"`
+	Properties  map[string]*schemaProperty `json:"properties"`
+}
+
+func TestWrapperSchema(t *testing.T) {
+	var schema schemaProperty
+	assert.NoError(t, json.Unmarshal(WrapperSchema(), &schema))
+
+	// Every field of the wrapper and the metadata is described
+	checkFields := func(typ reflect.Type, properties map[string]*schemaProperty) {
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			if assert.Contains(t, properties, name, typ.Field(i).Name) {
+				assert.NotEmpty(t, properties[name].Description, name)
+			}
+		}
+	}
+	checkFields(reflect.TypeOf(wrapper{}), schema.Properties)
+	metadata := schema.Properties["metadata"]
+	checkFields(reflect.TypeOf(Metadata{}), metadata.Properties)
+
+	assert.Equal(t, []interface{}{RngUclibc, RngMusl}, metadata.Properties["rng"].Enum)
+	assert.Equal(t, []interface{}{EndianLittle, EndianBig}, metadata.Properties["endian"].Enum)
+	assert.Equal(t, []interface{}{OriginWeb, OriginSoap, OriginBare}, metadata.Properties["origin"].Enum)
+	if assert.NotNil(t, schema.Properties["version"].Maximum) {
+		assert.Equal(t, WrapperVersion, *schema.Properties["version"].Maximum)
+	}
+
+	// The fixtures only use properties of the schema
+	for _, device := range devices {
+		wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, device, decryptedConfigFile))
+		assert.NoError(t, err)
+		var w map[string]json.RawMessage
+		assert.NoError(t, json.Unmarshal(wrapperJSON, &w))
+		for name := range w {
+			assert.Contains(t, schema.Properties, name, device)
+		}
+	}
+}
+
+func TestUnchecked(t *testing.T) {
+	_, configBytes, metadata := decryptFile(t, filepath.Join(testDataDir, rbr760, encryptedConfigFile))
+	wrapperJSON, err := ToJSON(configBytes, metadata, false, WithSalvageReport(&SalvageReport{}))
+	assert.NoError(t, err)
+	_, _, err = FromJSON(wrapperJSON)
+	assert.ErrorIs(t, err, ErrSalvaged)
+	uncheckedConfig, _, err := FromJSON(wrapperJSON, Unchecked())
+	assert.NoError(t, err)
+	assert.Equal(t, configBytes, uncheckedConfig)
+
+	wrapperJSON, err = os.ReadFile(filepath.Join(testDataDir, rbr760, decryptedConfigFile))
+	assert.NoError(t, err)
+	// Change the value in only one of the protected object and the config
+	loc := regexp.MustCompile(`"dgc.project.board_data.region": "[^"]*`).FindIndex(wrapperJSON)
+	wrapperJSON = append(wrapperJSON[:loc[1]:loc[1]], append([]byte("X"), wrapperJSON[loc[1]:]...)...)
+	_, _, err = FromJSON(wrapperJSON)
+	var protectedErr *ProtectedKeyError
+	assert.ErrorAs(t, err, &protectedErr)
+	_, _, err = FromJSON(wrapperJSON, Unchecked())
+	assert.NoError(t, err)
 }
 
 func FuzzDecrypt(f *testing.F) {
-	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, devices[0], encryptedConfigFile))
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
 	assert.NoError(f, err)
-	encryptedConfigSoap, err := os.ReadFile(filepath.Join(testDataDir, devices[0], encryptedConfigFileSoap))
+	encryptedConfigSoap, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFileSoap))
 	assert.NoError(f, err)
 
 	f.Add(encryptedConfig)
@@ -156,9 +1180,9 @@ func FuzzDecrypt(f *testing.F) {
 }
 
 func FuzzEncrypt(f *testing.F) {
-	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, devices[0], decryptedConfigFile))
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFile))
 	assert.NoError(f, err)
-	wrapperJSONSoap, err := os.ReadFile(filepath.Join(testDataDir, devices[0], decryptedConfigFileSoap))
+	wrapperJSONSoap, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFileSoap))
 	assert.NoError(f, err)
 
 	configBytes, metadata, err := FromJSON(wrapperJSON)
@@ -175,7 +1199,7 @@ func FuzzEncrypt(f *testing.F) {
 }
 
 func FuzzToJSON(f *testing.F) {
-	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, devices[0], encryptedConfigFile))
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
 	assert.NoError(f, err)
 	_, configBytes, metadata, err := Decrypt(encryptedConfig)
 	assert.NoError(f, err)
@@ -189,11 +1213,11 @@ func FuzzToJSON(f *testing.F) {
 }
 
 func FuzzFromJSON(f *testing.F) {
-	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, devices[0], decryptedConfigFile))
+	wrapperJSON, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFile))
 	assert.NoError(f, err)
-	wrapperJSONSoap, err := os.ReadFile(filepath.Join(testDataDir, devices[0], decryptedConfigFileSoap))
+	wrapperJSONSoap, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFileSoap))
 	assert.NoError(f, err)
-	wrapperJSONRaw, err := os.ReadFile(filepath.Join(testDataDir, devices[0], decryptedConfigFileRaw))
+	wrapperJSONRaw, err := os.ReadFile(filepath.Join(testDataDir, rbr50, decryptedConfigFileRaw))
 	assert.NoError(f, err)
 
 	f.Add(wrapperJSON)
@@ -204,6 +1228,150 @@ func FuzzFromJSON(f *testing.F) {
 	})
 }
 
+func TestKeystreamCache(t *testing.T) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(t, err)
+		header, _, metadata, err := Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+		input := encryptedConfig[metadata.HeaderOffset+headerSize:]
+
+		expected := xorCipherSimple(header, input, metadata)
+		resetKeystreams()
+		// Shorter lengths first, so the cached keystream has to be extended
+		for _, n := range []uint32{plausibleLen, header.Len / 2 &^ 7, header.Len} {
+			output, err := xorCipher(&Header{Len: n}, input, metadata)
+			assert.NoError(t, err)
+			assert.Equal(t, expected[:n], output)
+		}
+		// Only decryption, once the checksum verifies, adds to the cache
+		assert.Empty(t, keystreams.m)
+		_, _, _, err = Decrypt(encryptedConfig)
+		assert.NoError(t, err)
+		assert.Len(t, keystreams.m, 1)
+
+		// A config with a junk magic isn't cached
+		junk := append([]byte{}, encryptedConfig...)
+		junk[metadata.HeaderOffset] ^= 0xff
+		_, _, _, err = Decrypt(junk)
+		assert.Error(t, err)
+		assert.Len(t, keystreams.m, 1)
+	}
+
+	// The cache is bounded in bytes, evicting the least recently used keystreams
+	resetKeystreams()
+	stream := make([]byte, maxCachedKeystreamBytes/4)
+	for magic := uint32(0); magic < 8; magic++ {
+		cacheKeystream(&Metadata{Rng: RngMusl, RealMagic: magic}, stream)
+		// Keep the first one in use
+		_, err := getKeystream(&Metadata{Rng: RngMusl, RealMagic: 0}, 8)
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, keystreams.size, maxCachedKeystreamBytes)
+	assert.Len(t, keystreams.m, 4)
+	assert.Contains(t, keystreams.m, newKeystreamKey(&Metadata{Rng: RngMusl, RealMagic: 0}))
+	assert.Contains(t, keystreams.m, newKeystreamKey(&Metadata{Rng: RngMusl, RealMagic: 7}))
+	assert.NotContains(t, keystreams.m, newKeystreamKey(&Metadata{Rng: RngMusl, RealMagic: 1}))
+	resetKeystreams()
+}
+
+// xorCipherSimple is the straightforward implementation of xorCipher, without caching.
+func xorCipherSimple(header *Header, input []byte, metadata *Metadata) []byte {
+	randFunc := musl.NewRandomData(metadata.RealMagic).Rand
+	if metadata.Rng == RngUclibc {
+		randFunc = uclibc.NewRandomData(metadata.RealMagic).Rand
+	}
+	order, _ := metadata.ByteOrder()
+	wordSize, _ := metadata.wordSize()
+
+	output := make([]byte, header.Len)
+	for i := uint32(0); i < header.Len; i += uint32(wordSize) {
+		if wordSize == 8 {
+			order.PutUint64(output[i:], order.Uint64(input[i:])^uint64(randFunc()))
+		} else {
+			order.PutUint32(output[i:], order.Uint32(input[i:])^uint32(randFunc()))
+		}
+	}
+	return output
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, d := range devices {
+		encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, d, encryptedConfigFile))
+		assert.NoError(b, err)
+
+		b.Run(d+"/cold", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				resetKeystreams()
+				if _, _, _, err := Decrypt(encryptedConfig); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(d+"/cached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := Decrypt(encryptedConfig); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkXorCipher(b *testing.B) {
+	encryptedConfig, err := os.ReadFile(filepath.Join(testDataDir, rbr50, encryptedConfigFile))
+	assert.NoError(b, err)
+	header, _, metadata, err := Decrypt(encryptedConfig)
+	assert.NoError(b, err)
+	input := encryptedConfig[metadata.HeaderOffset+headerSize:]
+
+	b.Run("simple", func(b *testing.B) {
+		b.SetBytes(int64(header.Len))
+		for i := 0; i < b.N; i++ {
+			xorCipherSimple(header, input, metadata)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.SetBytes(int64(header.Len))
+		for i := 0; i < b.N; i++ {
+			if _, err := xorCipher(header, input, metadata); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func findDevices() []string {
+	entries, err := os.ReadDir(testDataDir)
+	if err != nil {
+		panic(err)
+	}
+	var devices []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(testDataDir, e.Name(), encryptedConfigFile)); e.IsDir() && err == nil {
+			devices = append(devices, e.Name())
+		}
+	}
+	return devices
+}
+
+// soapDevices returns the devices that also have a config downloaded over SOAP.
+func soapDevices() []string {
+	var soap []string
+	for _, d := range devices {
+		if _, err := os.Stat(filepath.Join(testDataDir, d, encryptedConfigFileSoap)); err == nil {
+			soap = append(soap, d)
+		}
+	}
+	return soap
+}
+
+// deviceModel returns the model of a test device, e.g., RBR50 for RBR50-BE.
+func deviceModel(device string) string {
+	model, _, _ := strings.Cut(device, "-")
+	return model
+}
+
 func decryptFile(t *testing.T, encryptedFile string) (*Header, []byte, *Metadata) {
 	encryptedConfig, err := os.ReadFile(encryptedFile)
 	assert.NoError(t, err)
@@ -211,3 +1379,14 @@ func decryptFile(t *testing.T, encryptedFile string) (*Header, []byte, *Metadata
 	assert.NoError(t, err)
 	return header, configBytes, metadata
 }
+
+// encryptRoundTrip encrypts a config with EncryptVerified and decrypts it again, checking that the config is unchanged.
+// It returns the encrypted config and the metadata found when decrypting it.
+func encryptRoundTrip(t *testing.T, configBytes []byte, metadata *Metadata, opts ...Option) ([]byte, *Metadata) {
+	encryptedConfig, err := EncryptVerified(configBytes, metadata, opts...)
+	assert.NoError(t, err)
+	_, decrypted, decryptedMetadata, err := Decrypt(encryptedConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, configBytes, decrypted)
+	return encryptedConfig, decryptedMetadata
+}
@@ -0,0 +1,233 @@
+package cfg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Config is a decrypted config and its metadata, for programs that read or edit configs.
+// Entries keep their original order; new entries are added at the end.
+type Config struct {
+	Metadata *Metadata
+
+	entries *orderedmap.OrderedMap[string, string]
+
+	// The encrypted config this was decrypted from, if any
+	source []byte
+
+	// Values of the protected keys and digest of the metadata when the config was opened, checked by Save.
+	// Nil for configs created by NewConfig.
+	protected map[string]string
+	integrity *Integrity
+}
+
+// NewConfig parses a decrypted config.
+func NewConfig(configBytes []byte, metadata *Metadata) (*Config, error) {
+	entries, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{Metadata: metadata, entries: entries}, nil
+}
+
+// ParseConfig parses an encrypted config or a JSON wrapper. Options are passed to FromJSON.
+func ParseConfig(b []byte, opts ...Option) (*Config, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		w, configBytes, err := fromJSON(b, newOptions(opts))
+		if err != nil {
+			return nil, err
+		}
+		c, err := NewConfig(configBytes, w.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		// Keep what the wrapper recorded at decryption, if anything
+		c.protected, c.integrity = w.Protected, w.Integrity
+		return c, c.recordOriginal()
+	}
+
+	_, configBytes, metadata, err := Decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewConfig(configBytes, metadata)
+	if err != nil {
+		return nil, err
+	}
+	c.source = b
+	return c, c.recordOriginal()
+}
+
+// recordOriginal records the protected values and the metadata digest, if they weren't read from a wrapper.
+func (c *Config) recordOriginal() error {
+	if c.protected == nil {
+		c.protected = protectedValues(c.entries)
+	}
+	if c.integrity == nil {
+		digest, err := metadataDigest(c.Metadata)
+		if err != nil {
+			return err
+		}
+		c.integrity = &Integrity{MetadataSHA256: digest}
+		if c.source != nil {
+			sum := sha256.Sum256(c.source)
+			c.integrity.SourceSHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	return nil
+}
+
+// Open reads an encrypted config or a JSON wrapper from a file. Options are passed to FromJSON.
+func Open(path string, opts ...Option) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(b, opts...)
+}
+
+// Save writes the config to a new file: as a JSON wrapper if the path ends in .json,
+// otherwise encrypted and verified with EncryptVerified. Options are passed to EncryptVerified.
+// Like FromJSON, it refuses changes to protected keys and the metadata unless AllowProtected or AllowMetadataChanges
+// is given; a wrapper keeps the values recorded when the config was opened, so the changes stay detectable.
+// It never overwrites a file: if path exists, the error satisfies errors.Is(err, fs.ErrExist).
+func (c *Config) Save(path string, opts ...Option) error {
+	o := newOptions(opts)
+	configBytes := c.Bytes()
+	if c.protected != nil {
+		if err := checkProtected(configBytes, c.protected, o.allowProtected); err != nil {
+			return err
+		}
+	}
+	if c.integrity != nil && !o.allowMetadataChanges {
+		digest, err := metadataDigest(c.Metadata)
+		if err != nil {
+			return err
+		}
+		if digest != c.integrity.MetadataSHA256 {
+			return ErrMetadataModified
+		}
+	}
+
+	var b []byte
+	var err error
+	if strings.HasSuffix(path, ".json") {
+		toJSONOpts := []Option{keepOriginal(c.protected, c.integrity)}
+		if c.source != nil {
+			toJSONOpts = append(toJSONOpts, WithSource(c.source))
+		}
+		b, err = ToJSON(configBytes, c.Metadata, false, toJSONOpts...)
+	} else {
+		b, err = EncryptVerified(configBytes, c.Metadata, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	return writeNewFile(path, b)
+}
+
+// keepOriginal makes ToJSON write the given protected values and integrity instead of recording new ones.
+func keepOriginal(protected map[string]string, integrity *Integrity) Option {
+	return func(o *options) {
+		o.protected = protected
+		o.integrity = integrity
+	}
+}
+
+// writeNewFile writes a file that must not exist yet.
+func writeNewFile(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Model identifies the model of the device the config belongs to. It returns nil if it can't be identified.
+func (c *Config) Model() *Model {
+	return IdentifyModel(c.entries)
+}
+
+// Get returns the value of an entry and whether it exists.
+func (c *Config) Get(key string) (string, bool) {
+	return c.entries.Get(key)
+}
+
+// Set changes the value of an entry, adding it if it doesn't exist.
+// Keys must be non-empty and can't contain '=', and neither keys nor values can contain null bytes,
+// since they couldn't be told apart from the separators of the serialized config.
+func (c *Config) Set(key, value string) error {
+	switch {
+	case key == "":
+		return errors.New("key is empty")
+	case strings.ContainsAny(key, "=\x00"):
+		return fmt.Errorf("key %q contains '=' or a null byte", key)
+	case strings.ContainsRune(value, 0):
+		return fmt.Errorf("value of %q contains a null byte", key)
+	}
+	c.entries.Set(key, value)
+	return nil
+}
+
+// Delete removes an entry and reports whether it existed.
+func (c *Config) Delete(key string) bool {
+	_, ok := c.entries.Delete(key)
+	return ok
+}
+
+// Len returns the number of entries.
+func (c *Config) Len() int {
+	return c.entries.Len()
+}
+
+// Keys returns the keys of all entries, in order.
+func (c *Config) Keys() []string {
+	keys := make([]string, 0, c.entries.Len())
+	for pair := c.entries.Oldest(); pair != nil; pair = pair.Next() {
+		keys = append(keys, pair.Key)
+	}
+	return keys
+}
+
+// Range calls f for each entry in order, until f returns false.
+func (c *Config) Range(f func(key, value string) bool) {
+	for pair := c.entries.Oldest(); pair != nil; pair = pair.Next() {
+		if !f(pair.Key, pair.Value) {
+			return
+		}
+	}
+}
+
+// Prefix returns the keys that start with prefix, in order.
+func (c *Config) Prefix(prefix string) []string {
+	var keys []string
+	for pair := c.entries.Oldest(); pair != nil; pair = pair.Next() {
+		if strings.HasPrefix(pair.Key, prefix) {
+			keys = append(keys, pair.Key)
+		}
+	}
+	return keys
+}
+
+// Bytes returns the decrypted config, ready to be passed to Encrypt.
+func (c *Config) Bytes() []byte {
+	b := serializeEntries(c.entries)
+	if c.Metadata == nil {
+		return b
+	}
+	if wordSize, err := c.Metadata.wordSize(); err == nil {
+		b = padToWordSize(b, wordSize)
+	}
+	return b
+}
@@ -0,0 +1,90 @@
+package cfg
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// HeaderError is returned when the container or header of an encrypted config is malformed.
+type HeaderError struct {
+	// Offset of the header in the encrypted file
+	Offset uint64
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("header at offset %v: %s", e.Offset, e.Reason)
+}
+
+// EntryError is returned when an entry of a decrypted config isn't of the form key=value.
+type EntryError struct {
+	// Offset of the entry in the decrypted config
+	Offset int
+
+	// Key of the entry, if it has one
+	Key string
+
+	// The entry as it appears in the decrypted config
+	Raw []byte
+}
+
+// Entries longer than this are abbreviated in error messages.
+const maxEntryErrorLen = 32
+
+func (e *EntryError) Error() string {
+	reason := "more than one '=' separator"
+	if !bytes.Contains(e.Raw, []byte{'='}) {
+		reason = "missing '=' separator"
+	}
+	if e.Key != "" {
+		return fmt.Sprintf("entry %q at offset %v: %s", e.Key, e.Offset, reason)
+	}
+	raw := string(e.Raw)
+	if len(raw) > maxEntryErrorLen {
+		raw = raw[:maxEntryErrorLen] + "..."
+	}
+	return fmt.Sprintf("entry %q at offset %v: %s", raw, e.Offset, reason)
+}
+
+// DuplicateKeyError is returned when a decrypted config has more than one entry with the same key.
+type DuplicateKeyError struct {
+	Key string
+
+	// Offsets of the entries in the decrypted config
+	Offsets []int
+}
+
+func (e *DuplicateKeyError) Error() string {
+	offsets := make([]string, len(e.Offsets))
+	for i, o := range e.Offsets {
+		offsets[i] = fmt.Sprint(o)
+	}
+	return fmt.Sprintf("duplicate key %q at offsets %s", e.Key, strings.Join(offsets, ", "))
+}
+
+// RedactedError is returned by FromJSON for a redacted wrapper whose secrets haven't been restored (see WithSecrets).
+type RedactedError struct {
+	// Keys that still hold placeholders, sorted
+	Keys []string
+}
+
+// Only this many keys are named in the message of a RedactedError.
+const maxRedactedErrorKeys = 3
+
+func (e *RedactedError) Error() string {
+	keys := strings.Join(e.Keys, ", ")
+	if len(e.Keys) > maxRedactedErrorKeys {
+		keys = strings.Join(e.Keys[:maxRedactedErrorKeys], ", ") + ", ..."
+	}
+	return fmt.Sprintf("config was redacted and %v secrets (%s) still hold placeholders; restore them with the secrets saved by Redact", len(e.Keys), keys)
+}
+
+// UnsupportedRNGError is returned when the metadata names an RNG that orbicfg doesn't implement.
+type UnsupportedRNGError struct {
+	Rng string
+}
+
+func (e *UnsupportedRNGError) Error() string {
+	return fmt.Sprintf("unsupported rng %q (must be %q or %q)", e.Rng, RngUclibc, RngMusl)
+}
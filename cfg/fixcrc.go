@@ -0,0 +1,113 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ChecksumReport describes the changes made by FixChecksum.
+type ChecksumReport struct {
+	OldChecksum uint32 `json:"old_checksum"`
+	NewChecksum uint32 `json:"new_checksum"`
+	Rng         string `json:"rng"`
+	Endian      string `json:"endian,omitempty"`
+	WordSize    int    `json:"word_size,omitempty"`
+
+	// Whether the decrypted config could be parsed into entries. If not, ParseError says why.
+	WellFormed bool   `json:"well_formed"`
+	ParseError string `json:"parse_error,omitempty"`
+}
+
+// FixChecksum recomputes the checksum of an encrypted config from its decrypted contents
+// and returns a copy of the encrypted config with the header's checksum rewritten in place.
+// Since the checksum can't be used to detect the RNG, the first RNG whose output parses into entries is chosen.
+func FixChecksum(encryptedConfig []byte) (fixed []byte, report *ChecksumReport, err error) {
+	type candidate struct {
+		offset   uint64
+		header   *Header
+		metadata *Metadata
+		override bool
+	}
+	var candidates []candidate
+	var headerErr error
+	for _, variant := range cipherVariants {
+		order, err := variant.ByteOrder()
+		if err != nil {
+			return nil, nil, err
+		}
+		offset, header, err := locateHeader(encryptedConfig, order)
+		if err != nil {
+			if headerErr == nil {
+				headerErr = err
+			}
+			continue
+		}
+		_, override := Overrides()[header.Magic]
+		for _, m := range rngCandidates(offset, header.Magic, variant) {
+			candidates = append(candidates, candidate{offset, header, m, override})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, headerErr
+	}
+
+	// If nothing is well-formed, an override still tells us the RNG for the default variant
+	chosen := -1
+	var configBytes []byte
+	var parseErr error
+	for i, c := range candidates {
+		b, err := xorCipher(c.header, encryptedConfig[c.offset+headerSize:], c.metadata)
+		if err != nil {
+			// The length doesn't fit this word size
+			continue
+		}
+		if _, err = parseEntries(b); err == nil {
+			chosen, configBytes, parseErr = i, b, nil
+			break
+		}
+		if i == 0 && c.override {
+			chosen, configBytes, parseErr = i, b, err
+		}
+	}
+	if chosen < 0 {
+		return nil, nil, errors.New("decrypted config isn't well-formed with any RNG or byte order; can't tell which one to use")
+	}
+	c := candidates[chosen]
+	order, _ := c.metadata.ByteOrder()
+
+	report = &ChecksumReport{
+		OldChecksum: c.header.Crc,
+		NewChecksum: Checksum(configBytes, order),
+		Rng:         c.metadata.Rng,
+		Endian:      c.metadata.Endian,
+		WordSize:    c.metadata.WordSize,
+		WellFormed:  parseErr == nil,
+	}
+	if parseErr != nil {
+		report.ParseError = parseErr.Error()
+	}
+
+	c.header.Crc = report.NewChecksum
+	fixed = make([]byte, len(encryptedConfig))
+	copy(fixed, encryptedConfig)
+	copy(fixed[c.offset:c.offset+headerSize], c.header.BytesOrder(order))
+	return fixed, report, nil
+}
+
+func (r *ChecksumReport) String() string {
+	s := fmt.Sprintf("old checksum: %#08x\nnew checksum: %#08x\nrng: %s\n", r.OldChecksum, r.NewChecksum, r.Rng)
+	if r.Endian != "" || r.WordSize != 0 {
+		endian, wordSize := r.Endian, r.WordSize
+		if endian == "" {
+			endian = EndianLittle
+		}
+		if wordSize == 0 {
+			wordSize = chunkSize
+		}
+		s += fmt.Sprintf("variant: %s-endian, %v-byte words\n", endian, wordSize)
+	}
+	if r.WellFormed {
+		return s + "entries: well-formed"
+	}
+	return s + "entries: NOT well-formed (" + r.ParseError + ")"
+}
@@ -0,0 +1,197 @@
+package cfg
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/fysac/orbicfg/rand/musl"
+	"github.com/fysac/orbicfg/rand/uclibc"
+)
+
+// Cached keystreams take up at most this many bytes in total; the least recently used are evicted first.
+const maxCachedKeystreamBytes = 8 << 20
+
+// plausibleLen is how much of the plaintext Decrypt checks before decrypting the rest with an RNG.
+const plausibleLen = 64
+
+// keystreamKey identifies a keystream. Besides the RNG and magic, the keystream depends on
+// the byte order and word size, since it's cached as bytes ready to be XORed with the data.
+type keystreamKey struct {
+	rng       string
+	realMagic uint32
+	endian    string
+	wordSize  int
+}
+
+// keystream is the output of a seeded RNG, generated on demand.
+type keystream struct {
+	mu       sync.Mutex
+	randFunc func() int32
+	order    binary.ByteOrder
+	wordSize int
+	stream   []byte
+}
+
+// cachedKeystream is an entry of the keystream cache. The stream is never modified once cached.
+type cachedKeystream struct {
+	key    keystreamKey
+	stream []byte
+}
+
+// Keystreams that produced a valid config, most recently used first
+var keystreams = struct {
+	sync.Mutex
+	lru  *list.List
+	m    map[keystreamKey]*list.Element
+	size int
+}{lru: list.New(), m: make(map[keystreamKey]*list.Element)}
+
+func newKeystreamKey(metadata *Metadata) keystreamKey {
+	canonical := metadata.canonical()
+	return keystreamKey{rng: metadata.Rng, realMagic: metadata.RealMagic, endian: canonical.Endian, wordSize: canonical.WordSize}
+}
+
+// getKeystream returns the first n bytes of the keystream for the given metadata, from the cache if it's there.
+// It doesn't add to the cache: the magic may come from an untrusted header, so only keystreams that
+// were shown to be right are cached, by cacheKeystream.
+func getKeystream(metadata *Metadata, n int) ([]byte, error) {
+	wordSize, err := metadata.wordSize()
+	if err != nil {
+		return nil, err
+	}
+	if n%wordSize != 0 {
+		return nil, fmt.Errorf("length %v is not divisible by word size (%v)", n, wordSize)
+	}
+
+	key := newKeystreamKey(metadata)
+	keystreams.Lock()
+	if e, ok := keystreams.m[key]; ok {
+		if c := e.Value.(*cachedKeystream); len(c.stream) >= n {
+			keystreams.lru.MoveToFront(e)
+			keystreams.Unlock()
+			return c.stream[:n], nil
+		}
+	}
+	keystreams.Unlock()
+
+	ks, err := newKeystream(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return ks.bytes(n), nil
+}
+
+// cacheKeystream caches the keystream of a config whose checksum verified, replacing a shorter one.
+func cacheKeystream(metadata *Metadata, stream []byte) {
+	if len(stream) > maxCachedKeystreamBytes {
+		return
+	}
+	key := newKeystreamKey(metadata)
+	keystreams.Lock()
+	defer keystreams.Unlock()
+	if e, ok := keystreams.m[key]; ok {
+		if len(e.Value.(*cachedKeystream).stream) >= len(stream) {
+			keystreams.lru.MoveToFront(e)
+			return
+		}
+		removeKeystream(e)
+	}
+	keystreams.m[key] = keystreams.lru.PushFront(&cachedKeystream{key: key, stream: stream})
+	keystreams.size += len(stream)
+	for keystreams.size > maxCachedKeystreamBytes {
+		removeKeystream(keystreams.lru.Back())
+	}
+}
+
+// removeKeystream removes an entry from the cache. The cache must be locked.
+func removeKeystream(e *list.Element) {
+	c := keystreams.lru.Remove(e).(*cachedKeystream)
+	delete(keystreams.m, c.key)
+	keystreams.size -= len(c.stream)
+}
+
+// newKeystream returns an uncached keystream for the given metadata.
+func newKeystream(metadata *Metadata) (*keystream, error) {
+	order, err := metadata.ByteOrder()
+	if err != nil {
+		return nil, err
+	}
+	wordSize, err := metadata.wordSize()
+	if err != nil {
+		return nil, err
+	}
+	ks := &keystream{order: order, wordSize: wordSize}
+	switch metadata.Rng {
+	case RngUclibc:
+		ks.randFunc = uclibc.NewRandomData(metadata.RealMagic).Rand
+	case RngMusl:
+		ks.randFunc = musl.NewRandomData(metadata.RealMagic).Rand
+	default:
+		return nil, &UnsupportedRNGError{Rng: metadata.Rng}
+	}
+	return ks, nil
+}
+
+// fill writes the next len(dst) bytes of the keystream to dst, which must be a whole number of words.
+func (ks *keystream) fill(dst []byte) {
+	for i := 0; i < len(dst); i += ks.wordSize {
+		// rand() is never negative, so it's zero-extended to fill an 8-byte word.
+		if ks.wordSize == 8 {
+			ks.order.PutUint64(dst[i:], uint64(ks.randFunc()))
+		} else {
+			ks.order.PutUint32(dst[i:], uint32(ks.randFunc()))
+		}
+	}
+}
+
+// bytes extends the keystream to at least n bytes and returns the first n.
+// Bytes already generated are never modified, so the result can be used after the lock is released.
+func (ks *keystream) bytes(n int) []byte {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if len(ks.stream) < n {
+		stream := make([]byte, n)
+		copy(stream, ks.stream)
+		ks.fill(stream[len(ks.stream):])
+		ks.stream = stream
+	}
+	return ks.stream[:n]
+}
+
+// resetKeystreams empties the keystream cache.
+func resetKeystreams() {
+	keystreams.Lock()
+	keystreams.lru.Init()
+	keystreams.m = make(map[keystreamKey]*list.Element)
+	keystreams.size = 0
+	keystreams.Unlock()
+}
+
+// xorBytes sets dst to a XOR b, 8 bytes at a time.
+func xorBytes(dst, a, b []byte) {
+	n := len(dst)
+	a, b = a[:n], b[:n]
+	i := 0
+	for ; i+8 <= n; i += 8 {
+		binary.LittleEndian.PutUint64(dst[i:], binary.LittleEndian.Uint64(a[i:])^binary.LittleEndian.Uint64(b[i:]))
+	}
+	for ; i < n; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// isPlausible reports whether the start of a decrypted config could be key=value text.
+// A wrong keystream almost always produces control characters within the first few bytes.
+func isPlausible(plaintext []byte) bool {
+	if len(plaintext) == 0 || plaintext[0] == '=' {
+		return false
+	}
+	for _, c := range plaintext {
+		if c < 0x20 && c != 0 && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package cfg
+
+import "fmt"
+
+// WrapperVersion is the version of the wrapper format written by ToJSON.
+// Wrappers without a version field are version 1.
+const WrapperVersion = 2
+
+// migrations[i] upgrades a wrapper from version i+1 to version i+2, in place.
+// The metadata digest is checked after migrating, so migrations must not change the digest of the metadata.
+var migrations = []func(w *wrapper) error{
+	// 1 -> 2: the origin was added to the metadata. It was always implied by the header offset.
+	func(w *wrapper) error {
+		if w.Metadata.Origin == "" {
+			w.Metadata.Origin = containerOrigin(w.Metadata.HeaderOffset)
+		}
+		return nil
+	},
+}
+
+// migrate upgrades a wrapper to WrapperVersion.
+func migrate(w *wrapper) error {
+	if w.Version == 0 {
+		w.Version = 1
+	}
+	if w.Version > WrapperVersion {
+		return fmt.Errorf("wrapper version %v is newer than the latest supported version (%v); please upgrade orbicfg", w.Version, WrapperVersion)
+	}
+	for ; w.Version < WrapperVersion; w.Version++ {
+		if err := migrations[w.Version-1](w); err != nil {
+			return fmt.Errorf("migrate wrapper from version %v: %w", w.Version, err)
+		}
+	}
+	return nil
+}
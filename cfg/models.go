@@ -0,0 +1,110 @@
+package cfg
+
+import (
+	"path"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Model describes what we know about the configs of a particular device.
+type Model struct {
+	Name string
+
+	// The config key holding the device's model name, used to identify the model of a decrypted config
+	NameKey string
+
+	// The config key holding the firmware version, if known
+	FirmwareKey string
+
+	// Keys holding secrets that aren't caught by secretPatterns
+	SecretKeys []string
+
+	// Magic values stated in the headers of the model's configs
+	StatedMagics []uint32
+
+	// Keys that every config for the model has
+	RequiredKeys []string
+
+	// Per-unit keys (e.g., MAC addresses, region, board data) that must not be changed by accident.
+	// May contain shell-style wildcards (see path.Match)
+	ProtectedKeys []string
+}
+
+var models = []*Model{
+	{
+		Name:         "RBR50",
+		NameKey:      "dgc_sysinfo_module_name",
+		StatedMagics: []uint32{0x20131224},
+		RequiredKeys: []string{
+			"dgc_sysinfo_module_name",
+			"lan_ipaddr",
+			"lan_netmask",
+			"http_username",
+			"wl_ssid",
+		},
+		SecretKeys: []string{
+			"admin_userAdmin",
+			"admin_userGuest",
+		},
+		ProtectedKeys: []string{
+			"board_region_default",
+			"lan_factory_mac",
+			"wan_factory_mac",
+		},
+	},
+	{
+		Name:         "RBR760",
+		NameKey:      "dgc.project.board_data.module_name",
+		FirmwareKey:  "dgc.project.firmware.version",
+		StatedMagics: []uint32{0x01346231},
+		RequiredKeys: []string{
+			"dgc.project.board_data.module_name",
+			"lan.global.ip_addr",
+			"lan.global.ip_mask",
+			"system.http.username",
+			"wireless.fh_ap_2g.ssid",
+		},
+		SecretKeys: []string{
+			"dgc.project.board_data.wps_pin",
+			"dgc.project.board_data.sn",
+		},
+		ProtectedKeys: []string{
+			"dgc.project.board_data.*",
+			"dgc.wireless.*.mac_addr",
+		},
+	},
+}
+
+func Models() []*Model {
+	return models
+}
+
+// LookupModel returns the model with the given name, or nil if it isn't known.
+func LookupModel(name string) *Model {
+	for _, m := range models {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// IsProtected reports whether key is one of the model's protected keys.
+func (m *Model) IsProtected(key string) bool {
+	for _, p := range m.ProtectedKeys {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IdentifyModel returns the model that a decrypted config belongs to, or nil if it can't be identified.
+func IdentifyModel(config *orderedmap.OrderedMap[string, string]) *Model {
+	for _, m := range models {
+		if name, ok := config.Get(m.NameKey); ok && name == m.Name {
+			return m
+		}
+	}
+	return nil
+}
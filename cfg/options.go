@@ -0,0 +1,118 @@
+package cfg
+
+// Option changes the behavior of Encrypt, Decrypt, FromJSON, or ToJSON.
+// Each option documents the functions it applies to; it's ignored by the others.
+type Option func(*options)
+
+type options struct {
+	noValidate     bool
+	allowProtected map[string]bool
+
+	allowMetadataChanges bool
+	source               []byte
+	salvageReport        *SalvageReport
+	provenance           bool
+	sourceName           string
+	origin               string
+	secrets              map[string]string
+	allowRedacted        bool
+	unchecked            bool
+
+	// Recorded when a Config was opened, for ToJSON to write back unchanged
+	protected map[string]string
+	integrity *Integrity
+
+	tracer Tracer
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NoValidate skips the validation of config values in Encrypt and FromJSON.
+// Use with care: the device may not accept the resulting config.
+func NoValidate() Option {
+	return func(o *options) {
+		o.noValidate = true
+	}
+}
+
+// AllowProtected lets FromJSON accept changes to the given protected keys (see Model.ProtectedKeys).
+func AllowProtected(keys ...string) Option {
+	return func(o *options) {
+		if o.allowProtected == nil {
+			o.allowProtected = make(map[string]bool)
+		}
+		for _, k := range keys {
+			o.allowProtected[k] = true
+		}
+	}
+}
+
+// AllowMetadataChanges lets FromJSON accept a wrapper whose metadata was edited after decryption.
+func AllowMetadataChanges() Option {
+	return func(o *options) {
+		o.allowMetadataChanges = true
+	}
+}
+
+// WithSource gives ToJSON the encrypted config that is being wrapped, so that its hash can be recorded.
+func WithSource(encryptedConfig []byte) Option {
+	return func(o *options) {
+		o.source = encryptedConfig
+	}
+}
+
+// WithSalvageReport makes ToJSON flag the wrapper as salvaged (see Salvage).
+func WithSalvageReport(report *SalvageReport) Option {
+	return func(o *options) {
+		o.salvageReport = report
+	}
+}
+
+// WithSecrets makes FromJSON restore the secrets removed by Redact (see Rehydrate) before validating the config.
+func WithSecrets(secrets map[string]string) Option {
+	return func(o *options) {
+		o.secrets = secrets
+	}
+}
+
+// AllowRedacted treats the placeholders left by Redact as ordinary values: ToJSON doesn't mark the wrapper
+// as redacted, and FromJSON accepts a redacted wrapper without WithSecrets.
+// Encrypting such a config replaces the device's secrets with placeholders.
+func AllowRedacted() Option {
+	return func(o *options) {
+		o.allowRedacted = true
+	}
+}
+
+// Unchecked makes FromJSON skip every check that protects the device: salvage, integrity, origin, protected keys,
+// redaction, and validation. It's for programs that only show a wrapper; never encrypt a config read with it.
+func Unchecked() Option {
+	return func(o *options) {
+		o.unchecked = true
+		o.noValidate = true
+		o.allowMetadataChanges = true
+		o.allowRedacted = true
+	}
+}
+
+// WithOrigin makes Encrypt and EncryptVerified produce a config with the container of the given origin
+// (OriginWeb, OriginSoap, or OriginBare), instead of the one given by the metadata.
+func WithOrigin(origin string) Option {
+	return func(o *options) {
+		o.origin = origin
+	}
+}
+
+// targetMetadata returns the metadata to encrypt with, taking WithOrigin into account.
+func (o *options) targetMetadata(metadata *Metadata) (*Metadata, error) {
+	if o.origin == "" {
+		return metadata, metadata.checkOrigin()
+	}
+	return metadata.withOrigin(o.origin)
+}
@@ -0,0 +1,228 @@
+package cfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a set of user-defined rules that a config must satisfy.
+//
+// Policies are written in YAML, e.g.:
+//
+//	rules:
+//	  - name: guest-isolation
+//	    keys: ["wireless.guest_ap_*.isolate_enable"]
+//	    enum: ["1"]
+//	    message: the guest network must be isolated
+//	  - name: lan-subnet
+//	    key: lan.global.ip_addr
+//	    cidr: 10.20.30.0/24
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule applies one or more matchers to every key selected by Key and Keys.
+// A value must satisfy all of the rule's matchers.
+type PolicyRule struct {
+	Name    string `yaml:"name"`
+	Message string `yaml:"message"`
+
+	// Key selectors; may contain shell-style wildcards (see path.Match)
+	Key  string   `yaml:"key"`
+	Keys []string `yaml:"keys"`
+
+	// If set, it isn't a violation for the selectors to match no keys
+	Optional bool `yaml:"optional"`
+
+	// Matchers
+	Regex string       `yaml:"regex"`
+	CIDR  string       `yaml:"cidr"`
+	Enum  []string     `yaml:"enum"`
+	Range *PolicyRange `yaml:"range"`
+
+	regex *regexp.Regexp
+	cidr  *net.IPNet
+}
+
+type PolicyRange struct {
+	Min *int64 `yaml:"min"`
+	Max *int64 `yaml:"max"`
+}
+
+// Violation is a config entry that doesn't satisfy a policy rule.
+type Violation struct {
+	Rule     string `json:"rule"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Expected string `json:"expected"`
+	Message  string `json:"message"`
+}
+
+func ParsePolicy(policyYAML []byte) (*Policy, error) {
+	p := &Policy{}
+	dec := yaml.NewDecoder(bytes.NewReader(policyYAML))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if len(p.Rules) == 0 {
+		return nil, errors.New("policy has no rules")
+	}
+
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.Key != "" {
+			r.Keys = append([]string{r.Key}, r.Keys...)
+		}
+		if len(r.Keys) == 0 {
+			return nil, fmt.Errorf("%s: 'key' or 'keys' is required", r.Name)
+		}
+		for _, k := range r.Keys {
+			if _, err := path.Match(k, ""); err != nil {
+				return nil, fmt.Errorf("%s: bad key selector %q: %w", r.Name, k, err)
+			}
+		}
+
+		if r.Regex == "" && r.CIDR == "" && r.Enum == nil && r.Range == nil {
+			return nil, fmt.Errorf("%s: at least one of 'regex', 'cidr', 'enum', or 'range' is required", r.Name)
+		}
+		if r.Regex != "" {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+			r.regex = re
+		}
+		if r.CIDR != "" {
+			_, ipNet, err := net.ParseCIDR(r.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+			r.cidr = ipNet
+		}
+		if r.Range != nil && r.Range.Min == nil && r.Range.Max == nil {
+			return nil, fmt.Errorf("%s: 'range' needs 'min' or 'max'", r.Name)
+		}
+	}
+	return p, nil
+}
+
+// Check evaluates every rule of the policy against a decrypted config.
+func (p *Policy) Check(configBytes []byte) ([]Violation, error) {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, err
+	}
+	model := IdentifyModel(config)
+
+	violations := []Violation{}
+	for _, r := range p.Rules {
+		matched := false
+		for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+			if !r.selects(pair.Key) {
+				continue
+			}
+			matched = true
+			if !r.matches(pair.Value) {
+				value := pair.Value
+				if IsSecretKey(pair.Key, model) {
+					// Like audit reports, check results may be shared
+					value = placeholder(value)
+				}
+				violations = append(violations, Violation{
+					Rule:     r.Name,
+					Key:      pair.Key,
+					Value:    value,
+					Expected: r.expected(),
+					Message:  r.Message,
+				})
+			}
+		}
+
+		if !matched && !r.Optional {
+			violations = append(violations, Violation{
+				Rule:     r.Name,
+				Key:      strings.Join(r.Keys, ", "),
+				Expected: "key to be present",
+				Message:  r.Message,
+			})
+		}
+	}
+	return violations, nil
+}
+
+func (r *PolicyRule) selects(key string) bool {
+	for _, k := range r.Keys {
+		if ok, _ := path.Match(k, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PolicyRule) matches(value string) bool {
+	if r.regex != nil && !r.regex.MatchString(value) {
+		return false
+	}
+	if r.cidr != nil {
+		ip := net.ParseIP(value)
+		if ip == nil || !r.cidr.Contains(ip) {
+			return false
+		}
+	}
+	if r.Enum != nil {
+		found := false
+		for _, e := range r.Enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Range != nil {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || (r.Range.Min != nil && n < *r.Range.Min) || (r.Range.Max != nil && n > *r.Range.Max) {
+			return false
+		}
+	}
+	return true
+}
+
+// expected describes the values accepted by the rule.
+func (r *PolicyRule) expected() string {
+	var parts []string
+	if r.regex != nil {
+		parts = append(parts, fmt.Sprintf("matches /%s/", r.Regex))
+	}
+	if r.cidr != nil {
+		parts = append(parts, "in "+r.cidr.String())
+	}
+	if r.Enum != nil {
+		parts = append(parts, fmt.Sprintf("one of %q", r.Enum))
+	}
+	if r.Range != nil {
+		switch {
+		case r.Range.Min != nil && r.Range.Max != nil:
+			parts = append(parts, fmt.Sprintf("between %d and %d", *r.Range.Min, *r.Range.Max))
+		case r.Range.Min != nil:
+			parts = append(parts, fmt.Sprintf(">= %d", *r.Range.Min))
+		default:
+			parts = append(parts, fmt.Sprintf("<= %d", *r.Range.Max))
+		}
+	}
+	return strings.Join(parts, " and ")
+}
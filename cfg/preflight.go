@@ -0,0 +1,134 @@
+package cfg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// PreflightCheck is the outcome of one of the checks run by Preflight.
+type PreflightCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// PreflightResult summarizes whether a device is expected to accept an encrypted config.
+type PreflightResult struct {
+	Model  string           `json:"model"`
+	Passed bool             `json:"passed"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightResult) add(name string, err error) bool {
+	c := PreflightCheck{Name: name, Passed: err == nil}
+	if err != nil {
+		c.Reason = err.Error()
+		r.Passed = false
+	}
+	r.Checks = append(r.Checks, c)
+	return c.Passed
+}
+
+// Preflight checks that an encrypted config will be accepted by a device of the given model.
+// If model is nil, the model is identified from the decrypted config.
+// Checks that depend on a failed check are skipped.
+func Preflight(encryptedConfig []byte, model *Model) *PreflightResult {
+	r := &PreflightResult{Passed: true}
+
+	// Only the marker and the file size are checked, not the tar header:
+	// orbicfg doesn't write one, and it's unknown whether datalib reads it
+	var offset uint64
+	if !r.add("container", func() error {
+		if !bytes.HasPrefix(encryptedConfig, []byte(tarMarker)) {
+			return nil
+		}
+		if len(encryptedConfig) <= configOffsetAfterTar {
+			return fmt.Errorf("file starts with %q, but is too small (%v) to hold a config at offset %v", tarMarker, len(encryptedConfig), configOffsetAfterTar)
+		}
+		offset = configOffsetAfterTar
+		return nil
+	}()) {
+		return r
+	}
+
+	// The header is valid if it parses in either byte order
+	_, err := parseHeader(encryptedConfig[offset:], binary.LittleEndian)
+	if err != nil {
+		if _, beErr := parseHeader(encryptedConfig[offset:], binary.BigEndian); beErr == nil {
+			err = nil
+		}
+	}
+	if !r.add("header", err) {
+		return r
+	}
+
+	header, configBytes, metadata, err := Decrypt(encryptedConfig)
+	if !r.add("checksum", err) {
+		return r
+	}
+
+	// Decrypt tolerates trailing data, but datalib rejects files that are longer than the header says
+	r.add("trailer", func() error {
+		if metadata.Trailer != nil {
+			return fmt.Errorf("%v bytes of trailing data after the config", metadata.Trailer.Len)
+		}
+		return nil
+	}())
+
+	config, err := parseEntries(configBytes)
+	if !r.add("entries", err) {
+		return r
+	}
+
+	identified := IdentifyModel(config)
+	if model == nil {
+		model = identified
+	}
+	if !r.add("model", func() error {
+		switch {
+		case model == nil:
+			return fmt.Errorf("unknown model; known models are %s", strings.Join(modelNames(), ", "))
+		case identified != nil && identified != model:
+			return fmt.Errorf("config is for %s, not %s", identified.Name, model.Name)
+		}
+		return nil
+	}()) {
+		return r
+	}
+	r.Model = model.Name
+
+	r.add("magic", func() error {
+		for _, m := range model.StatedMagics {
+			if header.Magic == m {
+				return nil
+			}
+		}
+		return fmt.Errorf("header magic %#08x is not used by %s", header.Magic, model.Name)
+	}())
+
+	r.add("required-keys", func() error {
+		var missing []string
+		for _, k := range model.RequiredKeys {
+			if _, ok := config.Get(k); !ok {
+				missing = append(missing, k)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}())
+
+	r.add("validation", Validate(configBytes))
+	return r
+}
+
+func modelNames() []string {
+	names := make([]string, len(models))
+	for i, m := range models {
+		names[i] = m.Name
+	}
+	return names
+}
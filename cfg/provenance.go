@@ -0,0 +1,71 @@
+package cfg
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Provenance records where a wrapper came from. It's informational only: FromJSON ignores it.
+type Provenance struct {
+	// Name of the encrypted config the wrapper was decrypted from. Its hash is in Integrity.SourceSHA256.
+	SourceName string `json:"source_name,omitempty"`
+
+	// Origin of the encrypted config (see Metadata.Origin)
+	ExportType string    `json:"export_type"`
+	Decrypted  time.Time `json:"decrypted"`
+	Version    string    `json:"orbicfg_version"`
+
+	// Model identified from the config, if any
+	Model string `json:"model,omitempty"`
+}
+
+// WithProvenance makes ToJSON record the provenance of the wrapper, with sourceName as the name of the
+// encrypted config.
+func WithProvenance(sourceName string) Option {
+	return func(o *options) {
+		o.provenance = true
+		o.sourceName = sourceName
+	}
+}
+
+func newProvenance(o *options, metadata *Metadata, model *Model) *Provenance {
+	p := &Provenance{
+		SourceName: o.sourceName,
+		ExportType: exportType(metadata),
+		Decrypted:  time.Now().UTC().Truncate(time.Second),
+		Version:    Version(),
+	}
+	if model != nil {
+		p.Model = model.Name
+	}
+	return p
+}
+
+func exportType(metadata *Metadata) string {
+	if metadata.Origin != "" {
+		return metadata.Origin
+	}
+	return containerOrigin(metadata.HeaderOffset)
+}
+
+// ReadProvenance returns the provenance recorded in a JSON wrapper, or nil if it has none.
+func ReadProvenance(wrapperJSON []byte) (*Provenance, error) {
+	var w struct {
+		Provenance *Provenance `json:"provenance"`
+	}
+	if err := json.Unmarshal(wrapperJSON, &w); err != nil {
+		return nil, err
+	}
+	return w.Provenance, nil
+}
+
+// ReadIntegrity returns the integrity recorded in a JSON wrapper, or nil if it has none.
+func ReadIntegrity(wrapperJSON []byte) (*Integrity, error) {
+	var w struct {
+		Integrity *Integrity `json:"integrity"`
+	}
+	if err := json.Unmarshal(wrapperJSON, &w); err != nil {
+		return nil, err
+	}
+	return w.Integrity, nil
+}
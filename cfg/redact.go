@@ -0,0 +1,175 @@
+package cfg
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// Keys matching any of these (lowercased) are considered to hold secrets.
+// They're anchored to the end of the key or to its segments, so that flags like have_set_passwd stay readable.
+var secretPatterns = []*regexp.Regexp{
+	// e.g., wan_pppoe_passwd, http_passwd_hashed, sysDNSPassword_tmp, wl_radiusSecret, wl_wpa2_psk
+	regexp.MustCompile(`(passwd|password|secret|psk|token)(_hashed|_tmp)?$`),
+	// Security question answers, e.g., PWD_answer1, so.gui.password.answer1
+	regexp.MustCompile(`(^|[._])answer\d*$`),
+	// e.g., wl_key1, wl_wep_64_key1, wl_radius_key, so.ddns.mynetgear.client_key, lan.rip.key_string
+	regexp.MustCompile(`(^|[._])key(\d+|_string)?$`),
+}
+
+// Keys matching any of these (lowercased) are never considered secret, even if they match secretPatterns.
+var secretExceptions = []*regexp.Regexp{
+	// Flags about the admin password, not the password itself
+	regexp.MustCompile(`^(have_set_passwd|enable_password_recovery|weak_password_check|flag_use_passwd_digest(_new)?)$`),
+	// Index of the WEP key in use, e.g., wl_key, wla1_key, wlg_arlo_key
+	regexp.MustCompile(`^wl[a-z0-9]*(_ext|_arlo)?_key$`),
+}
+
+// placeholderChar replaces every byte of a redacted value.
+const placeholderChar = "*"
+
+// IsSecretKey reports whether the value of key should be treated as a secret.
+// model may be nil, in which case only the generic patterns are used.
+func IsSecretKey(key string, model *Model) bool {
+	if model != nil {
+		for _, k := range model.SecretKeys {
+			if k == key {
+				return true
+			}
+		}
+	}
+
+	lower := strings.ToLower(key)
+	for _, e := range secretExceptions {
+		if e.MatchString(lower) {
+			return false
+		}
+	}
+	for _, p := range secretPatterns {
+		if p.MatchString(lower) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces the value of every secret-bearing entry with a placeholder of the same length,
+// so the redacted config has exactly the same size and layout as the original.
+// The original values are returned so that the config can later be restored with Rehydrate.
+func Redact(configBytes []byte) (redacted []byte, secrets map[string]string, err error) {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	model := IdentifyModel(config)
+
+	redacted = make([]byte, len(configBytes))
+	copy(redacted, configBytes)
+	secrets = make(map[string]string)
+
+	offset := 0
+	for _, entry := range bytes.Split(configBytes, []byte{0}) {
+		if key, value, ok := bytes.Cut(entry, []byte{'='}); ok && len(value) > 0 && IsSecretKey(string(key), model) {
+			secrets[string(key)] = string(value)
+			start := offset + len(key) + 1
+			copy(redacted[start:start+len(value)], placeholder(string(value)))
+		}
+		offset += len(entry) + 1
+	}
+	return redacted, secrets, nil
+}
+
+// Rehydrate restores the secrets removed by Redact, padding the config to the word size of metadata.
+// Entries whose placeholder was replaced by a new value, or that were deleted, are left alone.
+func Rehydrate(configBytes []byte, secrets map[string]string, metadata *Metadata) ([]byte, error) {
+	wordSize, err := metadata.wordSize()
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range secrets {
+		if current, ok := config.Get(key); ok && current == placeholder(value) {
+			config.Set(key, value)
+		}
+	}
+	return padToWordSize(serializeEntries(config), wordSize), nil
+}
+
+// redactedKeys returns the secret keys of the config that hold placeholders, sorted.
+func redactedKeys(config *orderedmap.OrderedMap[string, string], model *Model) []string {
+	var keys []string
+	for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+		if IsRedacted(pair.Value) && IsSecretKey(pair.Key, model) {
+			keys = append(keys, pair.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkRedacted verifies that none of the redacted keys still hold placeholders.
+// Keys that were given a new value or deleted are fine.
+func checkRedacted(configBytes []byte, redacted []string) error {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return fmt.Errorf("check redacted keys: %w", err)
+	}
+	var keys []string
+	for _, k := range redacted {
+		if v, ok := config.Get(k); ok && IsRedacted(v) {
+			keys = append(keys, k)
+		}
+	}
+	if keys != nil {
+		sort.Strings(keys)
+		return &RedactedError{Keys: keys}
+	}
+	return nil
+}
+
+// MaskValues replaces every byte of every value with a placeholder, keeping the keys and the layout.
+// Unlike Redact, it doesn't need well-formed entries, so it also works on part of a config.
+func MaskValues(configBytes []byte) []byte {
+	masked := make([]byte, len(configBytes))
+	copy(masked, configBytes)
+	for i, isValue := range valueBytes(configBytes) {
+		if isValue {
+			masked[i] = placeholderChar[0]
+		}
+	}
+	return masked
+}
+
+// valueBytes reports which bytes of configBytes belong to a value, i.e., follow the '=' of their entry.
+func valueBytes(configBytes []byte) []bool {
+	isValue := make([]bool, len(configBytes))
+	inValue := false
+	for i, c := range configBytes {
+		switch {
+		case c == 0:
+			inValue = false
+		case inValue:
+			isValue[i] = true
+		case c == '=':
+			inValue = true
+		}
+	}
+	return isValue
+}
+
+func placeholder(value string) string {
+	return strings.Repeat(placeholderChar, len(value))
+}
+
+// IsRedacted reports whether the value of an entry appears to be a placeholder left by Redact.
+func IsRedacted(value string) bool {
+	return value != "" && value == placeholder(value)
+}
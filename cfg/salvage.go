@@ -0,0 +1,158 @@
+package cfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	orderedmap "github.com/wk8/go-ordered-map/v2"
+)
+
+// SalvageReport describes what Salvage could and couldn't recover from a damaged config.
+type SalvageReport struct {
+	// Length stated in the header and the length actually available after it
+	StatedLen    uint32 `json:"stated_len"`
+	AvailableLen uint32 `json:"available_len"`
+
+	ChecksumValid bool `json:"checksum_valid"`
+
+	RecoveredEntries int            `json:"recovered_entries"`
+	Corrupted        []CorruptRange `json:"corrupted,omitempty"`
+}
+
+// CorruptRange is a range of the decrypted config that couldn't be recovered.
+type CorruptRange struct {
+	Offset int    `json:"offset"`
+	Len    int    `json:"len"`
+	Reason string `json:"reason"`
+}
+
+func (r *SalvageReport) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "stated length %v, available %v, checksum valid: %v\n", r.StatedLen, r.AvailableLen, r.ChecksumValid)
+	fmt.Fprintf(&b, "recovered %v entries, %v corrupted ranges", r.RecoveredEntries, len(r.Corrupted))
+	for _, c := range r.Corrupted {
+		fmt.Fprintf(&b, "\n  offset %v, length %v: %s", c.Offset, c.Len, c.Reason)
+	}
+	return b.String()
+}
+
+// Salvage decrypts as much of a damaged or truncated config as possible, ignoring length and checksum mismatches.
+// Entries that look corrupted are left out of the returned config and described in the report.
+func Salvage(encryptedConfig []byte) (configBytes []byte, metadata *Metadata, report *SalvageReport, err error) {
+	var offset uint64
+	if bytes.HasPrefix(encryptedConfig, []byte(tarMarker)) && len(encryptedConfig) > configOffsetAfterTar {
+		offset = configOffsetAfterTar
+	}
+	if len(encryptedConfig[offset:]) < headerSize {
+		return nil, nil, nil, &HeaderError{Offset: offset, Reason: fmt.Sprintf("config is smaller than header size (%v < %v)", len(encryptedConfig[offset:]), headerSize)}
+	}
+
+	data := encryptedConfig[offset+headerSize:]
+
+	// Keep the candidate that recovers the most entries
+	var best *orderedmap.OrderedMap[string, string]
+	var bestCorrupted []CorruptRange
+	var bestPlaintext []byte
+	var stated, header *Header
+	for _, variant := range cipherVariants {
+		order, err := variant.ByteOrder()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		wordSize, err := variant.wordSize()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		s := &Header{
+			Magic: order.Uint32(encryptedConfig[offset:]),
+			Len:   order.Uint32(encryptedConfig[offset+4:]),
+			Crc:   order.Uint32(encryptedConfig[offset+8:]),
+		}
+
+		// Decrypt whichever is shorter, in whole words
+		h := *s
+		if uint64(h.Len) > uint64(len(data)) {
+			h.Len = uint32(len(data))
+		}
+		h.Len -= h.Len % uint32(wordSize)
+		if h.Len == 0 {
+			continue
+		}
+
+		for _, m := range rngCandidates(offset, h.Magic, variant) {
+			plaintext, err := xorCipher(&h, data, m)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			config, corrupted := parseEntriesTolerant(plaintext)
+			if best == nil || config.Len() > best.Len() {
+				best, bestCorrupted, bestPlaintext, metadata = config, corrupted, plaintext, m
+				stated, header = s, &h
+			}
+		}
+	}
+	if best == nil {
+		return nil, nil, nil, errors.New("config has no data to salvage")
+	}
+
+	order, _ := metadata.ByteOrder()
+	report = &SalvageReport{StatedLen: stated.Len, AvailableLen: uint32(len(data))}
+	report.RecoveredEntries = best.Len()
+	report.Corrupted = bestCorrupted
+	report.ChecksumValid = stated.Len == header.Len && VerifyChecksum(stated, bestPlaintext, order) == nil
+	if best.Len() == 0 {
+		return nil, nil, report, errors.New("no entries could be recovered")
+	}
+	wordSize, _ := metadata.wordSize()
+	return padToWordSize(serializeEntries(best), wordSize), metadata, report, nil
+}
+
+// parseEntriesTolerant is like parseEntries, but skips entries that look corrupted instead of failing.
+func parseEntriesTolerant(configBytes []byte) (*orderedmap.OrderedMap[string, string], []CorruptRange) {
+	config := orderedmap.New[string, string]()
+	var corrupted []CorruptRange
+
+	// The plaintext ends with null padding, which isn't corruption
+	end := len(bytes.TrimRight(configBytes, "\x00"))
+
+	offset := 0
+	for _, entry := range bytes.Split(configBytes[:end], []byte{0}) {
+		entryOffset := offset
+		offset += len(entry) + 1
+		if len(entry) == 0 {
+			continue
+		}
+
+		reason := ""
+		key, value, ok := bytes.Cut(entry, []byte{'='})
+		switch {
+		case !isPrintable(entry):
+			reason = "non-printable bytes"
+		case !ok || len(key) == 0:
+			reason = "missing '=' separator"
+		}
+		if reason == "" {
+			if _, present := config.Get(string(key)); present {
+				reason = fmt.Sprintf("duplicate key %q", key)
+			}
+		}
+		if reason != "" {
+			corrupted = append(corrupted, CorruptRange{Offset: entryOffset, Len: len(entry), Reason: reason})
+			continue
+		}
+		config.Set(string(key), string(value))
+	}
+	return config, corrupted
+}
+
+// isPrintable reports whether b contains no control characters.
+// Bytes above 0x7f are allowed, since values (e.g., SSIDs) may be UTF-8.
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,11 @@
+package cfg
+
+import _ "embed"
+
+//go:embed schema.json
+var wrapperSchema []byte
+
+// WrapperSchema returns a JSON Schema (draft-07) of the JSON wrapper written by ToJSON.
+func WrapperSchema() []byte {
+	return append([]byte(nil), wrapperSchema...)
+}
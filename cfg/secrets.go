@@ -0,0 +1,142 @@
+package cfg
+
+// Credential is a username and secret stored in a config for a particular service.
+type Credential struct {
+	Service  string `json:"service"`
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
+// credentialKeys describes where a config stores the credentials for a service.
+type credentialKeys struct {
+	service string
+	// Key holding the username (or SSID); empty if the service has no username
+	username string
+	// Key holding the secret
+	secret string
+}
+
+// Wi-Fi networks, keyed by the prefix of their keys in the flat (RBR50) schema.
+var flatWifiNetworks = []struct{ prefix, name string }{
+	{"wl", "Wi-Fi 2.4 GHz"},
+	{"wla", "Wi-Fi 5 GHz"},
+	{"wlg1", "Guest Wi-Fi 2.4 GHz"},
+	{"wla1", "Guest Wi-Fi 5 GHz"},
+	{"wlg_ap_bh", "Backhaul AP 2.4 GHz"},
+	{"wla_2nd_ap_bh", "Backhaul AP 5 GHz"},
+	{"wlg_sta", "Backhaul STA 2.4 GHz"},
+	{"wla_2nd_sta", "Backhaul STA 5 GHz"},
+	{"wlg_arlo", "Arlo Wi-Fi"},
+	{"wlg_ext", "Extender Wi-Fi"},
+}
+
+// Wi-Fi networks, keyed by their section in the dotted (RBR760) schema.
+var dottedWifiNetworks = []struct{ section, name string }{
+	{"fh_ap_2g", "Wi-Fi 2.4 GHz"},
+	{"fh_ap_5g", "Wi-Fi 5 GHz"},
+	{"fh_ap_5g2", "Wi-Fi 5 GHz (2)"},
+	{"guest_ap_2g", "Guest Wi-Fi 2.4 GHz"},
+	{"guest_ap_5g", "Guest Wi-Fi 5 GHz"},
+	{"guest_ap_5g2", "Guest Wi-Fi 5 GHz (2)"},
+	{"bh_ap_2g", "Backhaul AP 2.4 GHz"},
+	{"bh_ap_5g", "Backhaul AP 5 GHz"},
+	{"bh_ap_5g2", "Backhaul AP 5 GHz (2)"},
+	{"fh_sta_2g", "Bridge STA 2.4 GHz"},
+	{"fh_sta_5g", "Bridge STA 5 GHz"},
+	{"fh_sta_5g2", "Bridge STA 5 GHz (2)"},
+}
+
+var credentialFamilies = buildCredentialFamilies()
+
+func buildCredentialFamilies() []credentialKeys {
+	families := []credentialKeys{
+		// Flat schema
+		{"Admin", "http_username", "http_passwd_hashed"},
+		{"Admin", "http_username", "http_passwd"},
+		{"Admin (guest)", "http_guestname", "http_guestpwd"},
+		{"Password recovery answer 1", "", "PWD_answer1"},
+		{"Password recovery answer 2", "", "PWD_answer2"},
+		{"PPPoE", "wan_pppoe_username", "wan_pppoe_passwd"},
+		{"PPPoE (session 1)", "wan_mulpppoe1_username", "wan_mulpppoe1_passwd"},
+		{"PPPoE (session 2)", "wan_mulpppoe2_username", "wan_mulpppoe2_password"},
+		{"PPPoE (session 2, east)", "wan_mulpppoe2_east_username", "wan_mulpppoe2_east_password"},
+		{"PPPoE (session 2, west)", "wan_mulpppoe2_west_username", "wan_mulpppoe2_west_password"},
+		{"PPPoE (session 2, other)", "wan_mulpppoe2_other_username", "wan_mulpppoe2_other_password"},
+		{"PPTP", "wan_pptp_username", "wan_pptp_password"},
+		{"L2TP", "wan_l2tp_username", "wan_l2tp_password"},
+		{"BPA", "wan_bpa_username", "wan_bpa_password"},
+		{"CDMA", "wan_cdma_username", "wan_cdma_password"},
+		{"DDNS", "sysDNSUser", "sysDNSPassword"},
+		{"SMTP", "email_username", "email_password"},
+		{"TR-069 ACS", "cwmp_acs_name", "cwmp_acs_password"},
+		{"TR-069 connection request", "cwmp_con_name", "cwmp_con_pass"},
+		{"Green download FTP", "green_download_fileTP_username", "green_download_fileTP_password"},
+		{"Legacy passwd", "", "passwd"},
+
+		// Dotted schema
+		{"Admin", "system.http.username", "system.http.password"},
+		{"Password recovery answer 1", "", "so.gui.password.answer1"},
+		{"Password recovery answer 2", "", "so.gui.password.answer2"},
+		{"Guest management", "so.gui.guest_mgmt.username", "so.gui.guest_mgmt.password"},
+		{"WPS PIN", "", "dgc.project.board_data.wps_pin"},
+		{"PPPoE", "wan.pppoe.username", "wan.pppoe.password"},
+		{"PPPoE (IPv6)", "ipv6.pppoe.username", "ipv6.pppoe.password"},
+		{"PPPoE (session 1)", "wan.mulpppoe.session1_username", "wan.mulpppoe.session1_password"},
+		{"PPPoE (session 2)", "wan.mulpppoe.session2_username", "wan.mulpppoe.session2_password"},
+		{"PPPoE (session 2, east)", "so.gui.mulpppoe.session2_east_username", "so.gui.mulpppoe.session2_east_password"},
+		{"PPPoE (session 2, west)", "so.gui.mulpppoe.session2_west_username", "so.gui.mulpppoe.session2_west_password"},
+		{"PPPoE (session 2, other)", "so.gui.mulpppoe.session2_other_username", "so.gui.mulpppoe.session2_other_password"},
+		{"PPPoE (Vodafone Spain)", "wan.vodafone_spain_pppoe.username", "wan.vodafone_spain_pppoe.password"},
+		{"PPPoE (Movistar Spain)", "wan.movistar_spain_pppoe.username", "wan.movistar_spain_pppoe.password"},
+		{"PPPoE (Orange France)", "wan.orange_france.username", "wan.orange_france_pppoe.password"},
+		{"PPTP", "wan.pptp.username", "wan.pptp.password"},
+		{"L2TP", "wan.l2tp.username", "wan.l2tp.password"},
+		{"DDNS (Dyn)", "ddns.dyn.username", "ddns.dyn.password"},
+		{"DDNS (No-IP)", "ddns.noip.username", "ddns.noip.password"},
+		{"DDNS (Oray)", "ddns.oray.username", "ddns.oray.password"},
+		{"DDNS (3322)", "ddns.ddns3322.username", "ddns.ddns3322.password"},
+		{"DDNS (NETGEAR)", "ddns.mynetgear.username", "ddns.mynetgear.password"},
+		{"SMTP", "email.settings.username", "email.settings.password"},
+		{"VPN client", "vpnclient.config.username", "vpnclient.config.password"},
+		{"RIP", "", "lan.rip.password"},
+	}
+
+	for _, n := range flatWifiNetworks {
+		for _, psk := range []struct{ suffix, name string }{
+			{"wpa2_psk", "WPA2"},
+			{"wpa1_psk", "WPA"},
+			{"wpas_psk", "WPA/WPA2"},
+			{"wpa_psk", "WPA, legacy"},
+		} {
+			families = append(families, credentialKeys{n.name + " (" + psk.name + ")", n.prefix + "_ssid", n.prefix + "_" + psk.suffix})
+		}
+		families = append(families, credentialKeys{n.name + " (RADIUS)", "", n.prefix + "_radiusSecret"})
+	}
+	for _, n := range dottedWifiNetworks {
+		families = append(families, credentialKeys{n.name, "wireless." + n.section + ".ssid", "wireless." + n.section + ".password"})
+	}
+	return families
+}
+
+// Credentials returns the credentials stored in a decrypted config, in a stable order.
+// If nonEmpty is set, credentials with an empty secret are omitted.
+func Credentials(configBytes []byte, nonEmpty bool) ([]Credential, error) {
+	config, err := parseEntries(configBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	for _, f := range credentialFamilies {
+		secret, ok := config.Get(f.secret)
+		if !ok || (nonEmpty && secret == "") {
+			continue
+		}
+		username := ""
+		if f.username != "" {
+			username, _ = config.Get(f.username)
+		}
+		creds = append(creds, Credential{Service: f.service, Username: username, Secret: secret})
+	}
+	return creds, nil
+}
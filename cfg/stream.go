@@ -0,0 +1,345 @@
+package cfg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted data is read and written in blocks of this size, which is a whole number of words.
+const streamBlockSize = 4096
+
+// DecryptReader decrypts an encrypted config as it's read, without holding more than a block of it in memory.
+// The RNG and cipher variant are detected from the start of the config, and the checksum is verified at EOF.
+type DecryptReader struct {
+	// Available once NewDecryptReader returns.
+	// Trailing data isn't read, so Metadata.Trailer is always nil.
+	Header   *Header
+	Metadata *Metadata
+
+	r         io.Reader
+	ks        *keystream
+	order     binary.ByteOrder
+	remaining uint32
+	block     []byte
+	keyBlock  []byte
+	buf       []byte
+	crc       uint32
+	err       error
+}
+
+// NewDecryptReader reads the header and the first few bytes of an encrypted config from r,
+// skipping over the container of web exports, to detect how it was encrypted.
+func NewDecryptReader(r io.Reader) (*DecryptReader, error) {
+	start := make([]byte, len(tarMarker))
+	n, err := io.ReadFull(r, start)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	var offset uint64
+	if bytes.Equal(start, []byte(tarMarker)) {
+		offset = configOffsetAfterTar
+		if _, err := io.CopyN(io.Discard, r, configOffsetAfterTar-int64(len(start))); err != nil {
+			return nil, &HeaderError{Offset: configOffsetAfterTar, Reason: fmt.Sprintf("file starts with %q, but is too small to hold a config at this offset: %v", tarMarker, err)}
+		}
+	} else {
+		r = io.MultiReader(bytes.NewReader(start[:n]), r)
+	}
+
+	headerBytes := make([]byte, headerSize)
+	if n, err := io.ReadFull(r, headerBytes); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, &HeaderError{Offset: offset, Reason: fmt.Sprintf("config is smaller than header size (%v < %v)", n, headerSize)}
+		}
+		return nil, err
+	}
+
+	// Read enough of the data to tell which variant and RNG produce plausible plaintext
+	prefix := make([]byte, plausibleLen)
+	n, err = io.ReadFull(r, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	prefix = prefix[:n]
+
+	d := &DecryptReader{r: r, crc: initialCrc}
+	var truncated error
+	for _, variant := range cipherVariants {
+		order, err := variant.ByteOrder()
+		if err != nil {
+			return nil, err
+		}
+		wordSize, err := variant.wordSize()
+		if err != nil {
+			return nil, err
+		}
+		header := &Header{
+			Magic: order.Uint32(headerBytes[:4]),
+			Len:   order.Uint32(headerBytes[4:8]),
+			Crc:   order.Uint32(headerBytes[8:headerSize]),
+		}
+		if header.Len%uint32(wordSize) != 0 || header.Len%chunkSize != 0 {
+			continue
+		}
+		if uint64(header.Len) > uint64(len(prefix)) && len(prefix) < plausibleLen {
+			// Not enough data to check, let alone decrypt
+			truncated = &HeaderError{Offset: offset, Reason: fmt.Sprintf("header length (%v) > length of config data (%v)", header.Len, len(prefix))}
+			continue
+		}
+		checkLen := uint32(len(prefix)) - uint32(len(prefix))%uint32(wordSize)
+		if header.Len < checkLen {
+			checkLen = header.Len
+		}
+		for _, m := range rngCandidates(offset, header.Magic, variant) {
+			ks, err := newKeystream(m)
+			if err != nil {
+				return nil, err
+			}
+			plaintext := make([]byte, checkLen)
+			ks.fill(plaintext)
+			xorBytes(plaintext, prefix, plaintext)
+			if !isPlausible(plaintext) && header.Len > 0 {
+				continue
+			}
+			d.Header, d.Metadata, d.ks, d.order = header, m, ks, order
+			d.remaining = header.Len - checkLen
+			d.setPlaintext(plaintext)
+			// Whatever was read beyond the checked bytes is decrypted with the rest of the data
+			d.r = io.MultiReader(bytes.NewReader(prefix[checkLen:]), r)
+			return d, nil
+		}
+	}
+	if truncated != nil {
+		return nil, truncated
+	}
+	return nil, errors.New("config isn't plausibly decrypted by any RNG or byte order")
+}
+
+// setPlaintext queues decrypted data to be returned by Read and adds it to the checksum.
+func (d *DecryptReader) setPlaintext(plaintext []byte) {
+	for i := 0; i+chunkSize <= len(plaintext); i += chunkSize {
+		d.crc -= d.order.Uint32(plaintext[i:])
+	}
+	d.buf = plaintext
+}
+
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.remaining == 0 {
+			if d.crc != d.Header.Crc {
+				d.err = ErrInvalidChecksum
+			} else {
+				d.err = io.EOF
+			}
+			continue
+		}
+
+		n := d.remaining
+		if n > streamBlockSize {
+			n = streamBlockSize
+		}
+		if d.block == nil {
+			d.block = make([]byte, streamBlockSize)
+			d.keyBlock = make([]byte, streamBlockSize)
+		}
+		if _, err := io.ReadFull(d.r, d.block[:n]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("config is truncated: %v bytes are missing: %w", d.remaining, io.ErrUnexpectedEOF)
+			}
+			d.err = err
+			continue
+		}
+		d.remaining -= n
+		d.ks.fill(d.keyBlock[:n])
+		xorBytes(d.block[:n], d.block[:n], d.keyBlock[:n])
+		d.setPlaintext(d.block[:n])
+	}
+
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// EncryptWriter encrypts a decrypted config as it's written. Close must be called to finish it.
+//
+// If the underlying writer is an io.WriteSeeker, such as a file, the encrypted data is written as it comes
+// and the header is filled in by Close. Otherwise, since the header holds the length and checksum of the
+// whole config and precedes it, the entire encrypted config is held in memory until Close.
+// Unlike Encrypt, EncryptWriter doesn't validate config values; use Validate first if needed.
+type EncryptWriter struct {
+	w         io.Writer
+	seeker    io.WriteSeeker
+	headerPos int64
+	buffered  *bytes.Buffer
+
+	metadata *Metadata
+	ks       *keystream
+	order    binary.ByteOrder
+	wordSize int
+	len      uint64
+	crc      uint32
+	pending  []byte
+	keyBlock []byte
+	closed   bool
+}
+
+// NewEncryptWriter returns an EncryptWriter that writes the config encrypted with metadata to w.
+// The container of web exports, if any, is written immediately.
+func NewEncryptWriter(w io.Writer, metadata *Metadata) (*EncryptWriter, error) {
+	ks, err := newKeystream(metadata)
+	if err != nil {
+		return nil, err
+	}
+	e := &EncryptWriter{w: w, metadata: metadata, ks: ks, order: ks.order, wordSize: ks.wordSize, crc: initialCrc}
+
+	if metadata.HeaderOffset != 0 {
+		if metadata.HeaderOffset < uint64(len(tarMarker)) {
+			return nil, fmt.Errorf("header offset %v is too small for a container", metadata.HeaderOffset)
+		}
+		if _, err := io.WriteString(w, tarMarker); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(w, zeroReader{}, int64(metadata.HeaderOffset)-int64(len(tarMarker))); err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := w.(io.WriteSeeker); ok {
+		// Pipes implement Seek, but it fails
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			e.seeker, e.headerPos = s, pos
+			// Reserve space for the header
+			if _, err := w.Write(make([]byte, headerSize)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if e.seeker == nil {
+		e.buffered = &bytes.Buffer{}
+	}
+	return e, nil
+}
+
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed EncryptWriter")
+	}
+	written := len(p)
+
+	// Complete a partial word from the previous write
+	if len(e.pending) > 0 {
+		n := e.wordSize - len(e.pending)
+		if n > len(p) {
+			n = len(p)
+		}
+		e.pending = append(e.pending, p[:n]...)
+		p = p[n:]
+		if len(e.pending) < e.wordSize {
+			return written, nil
+		}
+		if err := e.encrypt(e.pending); err != nil {
+			return 0, err
+		}
+		e.pending = e.pending[:0]
+	}
+
+	whole := len(p) - len(p)%e.wordSize
+	for i := 0; i < whole; i += streamBlockSize {
+		end := i + streamBlockSize
+		if end > whole {
+			end = whole
+		}
+		if err := e.encrypt(p[i:end]); err != nil {
+			return 0, err
+		}
+	}
+	e.pending = append(e.pending, p[whole:]...)
+	return written, nil
+}
+
+// encrypt encrypts and writes whole words.
+func (e *EncryptWriter) encrypt(plaintext []byte) error {
+	e.len += uint64(len(plaintext))
+	if e.len > 0xffffffff {
+		return errors.New("config is too large for the header")
+	}
+	for i := 0; i < len(plaintext); i += chunkSize {
+		e.crc -= e.order.Uint32(plaintext[i:])
+	}
+
+	if cap(e.keyBlock) < len(plaintext) {
+		e.keyBlock = make([]byte, len(plaintext))
+	}
+	encrypted := e.keyBlock[:len(plaintext)]
+	e.ks.fill(encrypted)
+	xorBytes(encrypted, plaintext, encrypted)
+
+	if e.buffered != nil {
+		e.buffered.Write(encrypted)
+		return nil
+	}
+	_, err := e.w.Write(encrypted)
+	return err
+}
+
+// Close writes the header and any trailer. It doesn't close the underlying writer.
+func (e *EncryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if len(e.pending) > 0 {
+		return fmt.Errorf("config length is not divisible by word size (%v)", e.wordSize)
+	}
+	if e.len == 0 {
+		return errors.New("config is empty")
+	}
+
+	header := Header{Magic: e.metadata.StatedMagic, Len: uint32(e.len), Crc: e.crc}
+	var trailer []byte
+	if e.metadata.Trailer != nil {
+		var err error
+		if trailer, err = e.metadata.Trailer.bytes(); err != nil {
+			return err
+		}
+	}
+
+	if e.buffered != nil {
+		for _, b := range [][]byte{header.BytesOrder(e.order), e.buffered.Bytes(), trailer} {
+			if _, err := e.w.Write(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := e.w.Write(trailer); err != nil {
+		return err
+	}
+	end, err := e.seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.seeker.Seek(e.headerPos, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(header.BytesOrder(e.order)); err != nil {
+		return err
+	}
+	_, err = e.seeker.Seek(end, io.SeekStart)
+	return err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,78 @@
+package cfg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tracer receives a log of each stage of Decrypt. *log.Logger satisfies it.
+type Tracer interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithTracer makes Decrypt log how it detected the container, header, override, and RNG to t,
+// including the first bytes of each attempt with config values masked.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+func (o *options) tracef(format string, v ...interface{}) {
+	if o.tracer != nil {
+		o.tracer.Printf(format, v...)
+	}
+}
+
+// traceDumpLen is how much of each attempt is dumped.
+const traceDumpLen = plausibleLen
+
+// traceDump logs the start of a decryption attempt as a hex dump with every config value masked.
+// Only plausible text is shown: garbage from a wrong RNG is the plaintext XORed with two keystreams
+// that anyone can regenerate from the magic, so showing it would leak the plaintext.
+func (o *options) traceDump(plaintext []byte) {
+	if o.tracer == nil {
+		return
+	}
+	if len(plaintext) > traceDumpLen {
+		plaintext = plaintext[:traceDumpLen]
+	}
+	if !isPlausible(plaintext) {
+		o.tracef("    first %v bytes aren't plausible text; not shown", len(plaintext))
+		return
+	}
+	for _, line := range redactedDump(plaintext) {
+		o.tracef("    %s", line)
+	}
+}
+
+// redactedDump formats b like `hexdump -C`, masking every byte of every value.
+func redactedDump(b []byte) []string {
+	masked := valueBytes(b)
+
+	var lines []string
+	for start := 0; start < len(b); start += 16 {
+		var hexPart, asciiPart strings.Builder
+		for i := start; i < start+16; i++ {
+			if i == start+8 {
+				hexPart.WriteByte(' ')
+			}
+			switch {
+			case i >= len(b):
+				hexPart.WriteString("   ")
+			case masked[i]:
+				hexPart.WriteString("** ")
+				asciiPart.WriteByte('*')
+			default:
+				fmt.Fprintf(&hexPart, "%02x ", b[i])
+				if b[i] >= 0x20 && b[i] < 0x7f {
+					asciiPart.WriteByte(b[i])
+				} else {
+					asciiPart.WriteByte('.')
+				}
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", start, hexPart.String(), asciiPart.String()))
+	}
+	return lines
+}
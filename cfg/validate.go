@@ -0,0 +1,178 @@
+package cfg
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ValidationError is a config value that doesn't have the shape expected for its key.
+type ValidationError struct {
+	Key      string
+	Value    string
+	Expected string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: value %q is not %s", e.Key, e.Value, e.Expected)
+}
+
+// ValidationErrors is every ValidationError found in a config.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// valueRule checks the values of every key matching one of its patterns (see path.Match).
+// Empty values are always accepted, since most keys are empty until the feature they belong to is set up.
+type valueRule struct {
+	keys     []string
+	except   []string
+	expected string
+	valid    func(value string) bool
+}
+
+var (
+	macRegex        = regexp.MustCompile(`^([0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}$`)
+	timeRegex       = regexp.MustCompile(`^(([01]?[0-9]|2[0-3]):[0-5]?[0-9]|24:0?0)$`)
+	hexKeyRegex     = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+	printableRegex  = regexp.MustCompile(`^[\x20-\x7e]*$`)
+	portNumberRegex = regexp.MustCompile(`^[0-9]{1,5}$`)
+)
+
+var valueRules = []valueRule{
+	{
+		keys: []string{
+			"lan_ipaddr", "old_lan_ipaddr", "arlo_lan_ipaddr", "wan_ipaddr", "ap_ipaddr", "bridge_ipaddr", "extender_ipaddr",
+			"dhcp_start", "dhcp_end", "arlo_dhcp_start", "arlo_dhcp_end",
+			"lan.global.ip_addr", "lan.dhcps.start_ip_addr", "lan.dhcps.end_ip_addr",
+			"wan.ether.fixed_ip_addr", "network.*.fixed_ip_addr", "network.*.fixed_ip_gateway",
+		},
+		expected: "an IPv4 address",
+		valid:    isIPv4,
+	},
+	{
+		keys:     []string{"ipv6_fixed_lan_ip", "ipv6_fixed_wan_ip", "ipv6.fixed.lan_ip_addr", "ipv6.fixed.wan_ip_addr"},
+		expected: "an IPv6 address",
+		valid: func(value string) bool {
+			ip := net.ParseIP(value)
+			return ip != nil && strings.Contains(value, ":")
+		},
+	},
+	{
+		keys: []string{
+			"lan_netmask", "arlo_lan_netmask", "wan_netmask", "ap_netmask", "bridge_netmask", "extender_netmask", "*_dhcp_netmask",
+			"lan.global.ip_mask", "wan.ether.fixed_ip_mask", "network.*.fixed_ip_subnet",
+		},
+		expected: "a netmask",
+		valid: func(value string) bool {
+			ip := net.ParseIP(value).To4()
+			if ip == nil || !isIPv4(value) {
+				return false
+			}
+			_, bits := net.IPMask(ip).Size()
+			return bits != 0
+		},
+	},
+	{
+		keys:     []string{"lan_factory_mac", "wan_factory_mac", "cur_wanmac", "wan_remote_mac", "*_this_mac", "*mac_addr"},
+		expected: "a MAC address",
+		valid:    macRegex.MatchString,
+	},
+	{
+		keys:     []string{"endis_*", "*_enable", "*.enable"},
+		expected: "0 or 1",
+		valid: func(value string) bool {
+			return value == "0" || value == "1"
+		},
+	},
+	{
+		keys:     []string{"*_port", "*.port", "*port_num"},
+		except:   []string{"*_to_port"},
+		expected: "a port number (1-65535)",
+		valid: func(value string) bool {
+			if !portNumberRegex.MatchString(value) {
+				return false
+			}
+			var port int
+			fmt.Sscan(value, &port)
+			return port >= 1 && port <= 65535
+		},
+	},
+	{
+		keys:     []string{"*_ssid", "*.ssid"},
+		expected: "an SSID (at most 32 bytes)",
+		valid: func(value string) bool {
+			return len(value) <= 32
+		},
+	},
+	{
+		keys:     []string{"*_psk", "wireless.*.password"},
+		expected: "a WPA passphrase (8-63 printable ASCII characters or 64 hex digits)",
+		valid: func(value string) bool {
+			return (len(value) >= 8 && len(value) <= 63 && printableRegex.MatchString(value)) || hexKeyRegex.MatchString(value)
+		},
+	},
+	{
+		keys:     []string{"*_block_time", "schedule.block.*start_time", "schedule.block.*end_time", "*counter_time"},
+		expected: "a time of day (HH:MM)",
+		valid:    timeRegex.MatchString,
+	},
+}
+
+func isIPv4(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.To4() != nil && !strings.Contains(value, ":")
+}
+
+func (r *valueRule) applies(key string) bool {
+	for _, e := range r.except {
+		if ok, _ := path.Match(e, key); ok {
+			return false
+		}
+	}
+	for _, k := range r.keys {
+		if ok, _ := path.Match(k, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the values of known keys in a decrypted config have the expected shape.
+// If any don't, the returned error is a ValidationErrors naming each offending key.
+// Entries that don't parse (e.g., duplicate keys in a raw config) are skipped: they're not
+// value errors, and raw configs don't need to be well-formed.
+func Validate(configBytes []byte) error {
+	config, _ := parseEntriesTolerant(configBytes)
+	model := IdentifyModel(config)
+
+	var errs ValidationErrors
+	for pair := config.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.Value == "" {
+			continue
+		}
+		for i := range valueRules {
+			r := &valueRules[i]
+			if r.applies(pair.Key) && !r.valid(pair.Value) {
+				value := pair.Value
+				if IsSecretKey(pair.Key, model) {
+					value = placeholder(value)
+				}
+				errs = append(errs, &ValidationError{Key: pair.Key, Value: value, Expected: r.expected})
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package cfg
+
+import "runtime/debug"
+
+const modulePath = "github.com/fysac/orbicfg"
+
+// Version returns the version of orbicfg that the running program was built with, or "(devel)" if it's unknown.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		v := info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				v += " " + s.Value
+			}
+		}
+		return v
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "(devel)"
+}
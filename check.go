@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type checkReport struct {
+	File       string          `json:"file"`
+	Error      string          `json:"error,omitempty"`
+	Violations []cfg.Violation `json:"violations"`
+}
+
+func checkCmd(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	rulesFile := fs.String("rules", "", "YAML policy to check the configs against (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg check -rules policy.yaml <config.cfg|wrapper.json>...")
+		fs.PrintDefaults()
+	}
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if *rulesFile == "" || fs.NArg() == 0 {
+		failUsage(fs, "check needs -rules and at least one file")
+	}
+
+	policyYAML, err := os.ReadFile(*rulesFile)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	policy, err := cfg.ParsePolicy(policyYAML)
+	if err != nil {
+		fail(&parseError{fmt.Errorf("parse policy: %w", err)}, nil, nil)
+	}
+
+	failed := false
+	var reports []checkReport
+	for _, name := range fs.Args() {
+		r := checkReport{File: name, Violations: []cfg.Violation{}}
+		configBytes, _, err := readConfig(name)
+		if err == nil {
+			var violations []cfg.Violation
+			if violations, err = policy.Check(configBytes); err == nil {
+				r.Violations = violations
+			}
+		}
+		if err != nil {
+			r.Error = err.Error()
+			failed = true
+		}
+		if len(r.Violations) > 0 {
+			failed = true
+		}
+		reports = append(reports, r)
+	}
+
+	done(reports, nil, nil, failed, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, r := range reports {
+			if r.Error != "" {
+				fmt.Fprintf(w, "%s: error: %s\n", r.File, r.Error)
+				continue
+			}
+			for _, v := range r.Violations {
+				fmt.Fprintf(w, "%s: %s\t%s\tvalue %q\texpected %s\t%s\n", r.File, v.Rule, v.Key, v.Value, v.Expected, v.Message)
+			}
+		}
+		w.Flush()
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type decryptOptions struct {
+	raw     bool
+	redact  bool
+	salvage bool
+	verbose bool
+	soap    bool
+}
+
+// addDecryptFlags defines the flags shared by -decrypt and the decrypt command.
+func addDecryptFlags(fs *flag.FlagSet) *decryptOptions {
+	o := &decryptOptions{}
+	fs.BoolVar(&o.raw, "raw", false, "decrypt the raw bytes to a Base64-encoded field")
+	fs.BoolVar(&o.redact, "redact", false, "replace secret values with placeholders and save the originals to <out>.secrets.json")
+	fs.BoolVar(&o.salvage, "salvage", false, "recover what can be recovered from a damaged or truncated config")
+	fs.BoolVar(&o.verbose, "v", false, "log each stage of decryption to stderr, with config values masked")
+	fs.BoolVar(&o.soap, "soap", false, "record that the config was downloaded over SOAP, which can't be detected")
+	return o
+}
+
+func decryptCmd(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	o := addDecryptFlags(fs)
+	b := addBatchFlags(fs)
+	outputFile := fs.String("out", "", "output file, or output directory with -batch (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg decrypt [flags] -out decrypted.json <config.cfg>")
+		fmt.Fprintln(fs.Output(), "       orbicfg decrypt [flags] -batch DIR -out OUTDIR")
+		fs.PrintDefaults()
+	}
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if *outputFile == "" {
+		failUsage(fs, "decrypt needs an output file")
+	}
+
+	if b.dir != "" {
+		runBatch(fs, b, *outputFile, decryptedName, func(in, out string) ([]string, *cfg.Metadata, error) {
+			return decryptToFile(in, out, o)
+		})
+		return
+	}
+	if fs.NArg() != 1 {
+		failUsage(fs, "decrypt needs exactly one file")
+	}
+	warnings, metadata, err := decryptToFile(fs.Arg(0), *outputFile, o)
+	if err != nil {
+		fail(err, warnings, metadata)
+	}
+	done(nil, warnings, metadata, false, nil)
+}
+
+// decryptToFile decrypts inputFile and writes its JSON wrapper to outputFile.
+// It returns any warnings and the detected metadata, which may be set even if there's an error.
+func decryptToFile(inputFile, outputFile string, o *decryptOptions) (warnings []string, metadata *cfg.Metadata, err error) {
+	b, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	toJSONOpts := []cfg.Option{cfg.WithSource(b), cfg.WithProvenance(filepath.Base(inputFile))}
+	var configBytes []byte
+	if o.salvage {
+		var report *cfg.SalvageReport
+		configBytes, metadata, report, err = cfg.Salvage(b)
+		if report != nil {
+			warnings = append(warnings, report.String())
+		}
+		if err != nil {
+			return warnings, metadata, fmt.Errorf("salvage config: %w", err)
+		}
+		toJSONOpts = append(toJSONOpts, cfg.WithSalvageReport(report))
+	} else {
+		var decryptOpts []cfg.Option
+		if o.verbose {
+			decryptOpts = append(decryptOpts, cfg.WithTracer(log.New(os.Stderr, inputFile+": ", 0)))
+		}
+		_, configBytes, metadata, err = cfg.Decrypt(b, decryptOpts...)
+		if err != nil {
+			hint := "If the config is damaged, -salvage may be able to recover some of it.\n" + openIssueMsg
+			if location := locationHint(err, ""); location != "" {
+				hint = location + "\n" + hint
+			}
+			return nil, metadata, &hintError{err: fmt.Errorf("decrypt config: %w", err), hint: hint}
+		}
+		if metadata.Trailer != nil {
+			warnings = append(warnings, fmt.Sprintf("warning: ignoring %v bytes of trailing data after the config; they'll be re-appended on encryption", metadata.Trailer.Len))
+		}
+	}
+	if o.soap {
+		if metadata.Origin != cfg.OriginBare {
+			return warnings, metadata, fmt.Errorf("config has a %q container, so it wasn't downloaded over SOAP", "photos.tar")
+		}
+		metadata.Origin = cfg.OriginSoap
+	}
+	var secretsJSON []byte
+	if o.redact {
+		var secrets map[string]string
+		configBytes, secrets, err = cfg.Redact(configBytes)
+		if err != nil {
+			return warnings, metadata, fmt.Errorf("redact config: %w", err)
+		}
+		if secretsJSON, err = json.MarshalIndent(secrets, "", "    "); err != nil {
+			return warnings, metadata, err
+		}
+	}
+	wrapperJSON, err := cfg.ToJSON(configBytes, metadata, o.raw, toJSONOpts...)
+	if err != nil {
+		hint := openIssueMsg
+		if location := locationHint(err, "the decrypted config"); location != "" {
+			hint = location + " Decrypt with -raw to inspect it."
+		}
+		return warnings, metadata, &hintError{err: fmt.Errorf("create json wrapper: %w", err), hint: hint}
+	}
+	if err := writeFileNoTrunc(outputFile, wrapperJSON); err != nil {
+		return warnings, metadata, err
+	}
+	if secretsJSON != nil {
+		// A redacted wrapper can't be encrypted without its secrets, so don't leave it behind
+		if err := writeFileNoTrunc(outputFile+secretsFileSuffix, append(secretsJSON, '\n')); err != nil {
+			os.Remove(outputFile)
+			return warnings, metadata, err
+		}
+	}
+	return warnings, metadata, nil
+}
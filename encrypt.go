@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type encryptOptions struct {
+	rehydrate            string
+	noValidate           bool
+	allowProtected       stringList
+	allowMetadataChanges bool
+	noVerify             bool
+	origin               string
+}
+
+// addEncryptFlags defines the flags shared by -encrypt and the encrypt command.
+func addEncryptFlags(fs *flag.FlagSet) *encryptOptions {
+	o := &encryptOptions{}
+	fs.StringVar(&o.rehydrate, "rehydrate", "", "restore secrets from this file (written by -redact) before encrypting")
+	fs.BoolVar(&o.noValidate, "no-validate", false, "don't check config values before encrypting (may produce a config that bricks your device)")
+	fs.Var(&o.allowProtected, "allow-protected", "allow encrypting a config in which this protected `key` was changed (may be repeated)")
+	fs.BoolVar(&o.allowMetadataChanges, "i-know-what-im-doing", false, "encrypt even if the wrapper's metadata was modified")
+	fs.BoolVar(&o.noVerify, "no-verify", false, "don't decrypt the encrypted config to verify it before writing")
+	fs.StringVar(&o.origin, "origin", "", "produce the container for this origin: web, soap, or bare (default: the origin of the wrapper)")
+	return o
+}
+
+// checkFlags exits if a flag has an invalid value.
+func (o *encryptOptions) checkFlags(fs *flag.FlagSet) {
+	switch o.origin {
+	case "", cfg.OriginWeb, cfg.OriginSoap, cfg.OriginBare:
+	default:
+		failUsage(fs, fmt.Sprintf("-origin must be %s, %s, or %s", cfg.OriginWeb, cfg.OriginSoap, cfg.OriginBare))
+	}
+}
+
+func (o *encryptOptions) cfgOptions() []cfg.Option {
+	var opts []cfg.Option
+	if o.noValidate {
+		opts = append(opts, cfg.NoValidate())
+	}
+	if o.allowMetadataChanges {
+		opts = append(opts, cfg.AllowMetadataChanges())
+	}
+	if len(o.allowProtected) > 0 {
+		opts = append(opts, cfg.AllowProtected(o.allowProtected...))
+	}
+	if o.origin != "" {
+		opts = append(opts, cfg.WithOrigin(o.origin))
+	}
+	return opts
+}
+
+func encryptCmd(args []string) {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	o := addEncryptFlags(fs)
+	b := addBatchFlags(fs)
+	outputFile := fs.String("out", "", "output file, or output directory with -batch (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg encrypt [flags] -out encrypted.cfg <wrapper.json>")
+		fmt.Fprintln(fs.Output(), "       orbicfg encrypt [flags] -batch DIR -out OUTDIR")
+		fs.PrintDefaults()
+	}
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if *outputFile == "" {
+		failUsage(fs, "encrypt needs an output file")
+	}
+	o.checkFlags(fs)
+
+	if b.dir != "" {
+		if o.rehydrate != "" {
+			failUsage(fs, fmt.Sprintf("-rehydrate can't be used with -batch; each wrapper is rehydrated from its own %s file, if any", secretsFileSuffix))
+		}
+		runBatch(fs, b, *outputFile, encryptedName, func(in, out string) ([]string, *cfg.Metadata, error) {
+			fileOpts := *o
+			if _, err := os.Stat(in + secretsFileSuffix); err == nil {
+				fileOpts.rehydrate = in + secretsFileSuffix
+			}
+			metadata, err := encryptToFile(in, out, &fileOpts)
+			return nil, metadata, err
+		})
+		return
+	}
+	if fs.NArg() != 1 {
+		failUsage(fs, "encrypt needs exactly one file")
+	}
+	metadata, err := encryptToFile(fs.Arg(0), *outputFile, o)
+	if err != nil {
+		fail(err, nil, metadata)
+	}
+	done(nil, nil, metadata, false, nil)
+}
+
+// encryptToFile encrypts the JSON wrapper in inputFile and writes the encrypted config to outputFile.
+// It returns the metadata of the wrapper, if it could be parsed.
+func encryptToFile(inputFile, outputFile string, o *encryptOptions) (*cfg.Metadata, error) {
+	wrapperJSON, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	opts := o.cfgOptions()
+	fromJSONOpts := opts
+	if o.rehydrate != "" {
+		secretsJSON, err := os.ReadFile(o.rehydrate)
+		if err != nil {
+			return nil, err
+		}
+		var secrets map[string]string
+		if err := json.Unmarshal(secretsJSON, &secrets); err != nil {
+			return nil, &parseError{fmt.Errorf("parse secrets file: %w", err)}
+		}
+		fromJSONOpts = append([]cfg.Option{cfg.WithSecrets(secrets)}, opts...)
+	}
+	configBytes, metadata, err := cfg.FromJSON(wrapperJSON, fromJSONOpts...)
+	if err != nil {
+		var protectedErr *cfg.ProtectedKeyError
+		if errors.As(err, &protectedErr) {
+			return nil, &hintError{err: err, hint: "If you really mean to change it, pass -allow-protected " + protectedErr.Key}
+		}
+		var redactedErr *cfg.RedactedError
+		if errors.As(err, &redactedErr) {
+			hint := fmt.Sprintf("Pass -rehydrate with the %s file that -redact wrote next to the wrapper.", secretsFileSuffix)
+			if o.rehydrate != "" {
+				hint = fmt.Sprintf("%s doesn't hold these secrets. Set them in the wrapper instead.", o.rehydrate)
+			}
+			return nil, &hintError{err: err, hint: hint}
+		}
+		if errors.Is(err, cfg.ErrMetadataModified) {
+			return nil, &hintError{err: err, hint: "The metadata should not be modified. If you really mean to, pass -i-know-what-im-doing"}
+		}
+		err = fmt.Errorf("parse json wrapper: %w", err)
+		if exitCode(err) == exitFailed {
+			err = &parseError{err}
+		}
+		if location := locationHint(err, "the config (Base64-decoded, if it's in config_raw)"); location != "" {
+			return nil, &hintError{err: err, hint: location}
+		}
+		return nil, err
+	}
+	encrypt := cfg.EncryptVerified
+	if o.noVerify {
+		encrypt = cfg.Encrypt
+	}
+	encryptedConfig, err := encrypt(configBytes, metadata, opts...)
+	if err != nil {
+		return metadata, &hintError{err: fmt.Errorf("encrypt config: %w", err), hint: openIssueMsg}
+	}
+	return metadata, writeFileNoTrunc(outputFile, encryptedConfig)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+func fixcrcCmd(args []string) {
+	fs := flag.NewFlagSet("fixcrc", flag.ExitOnError)
+	outputFile := fs.String("out", "", "output file for the fixed config (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg fixcrc -out fixed.cfg <config.cfg>")
+		fs.PrintDefaults()
+	}
+	name := parseCmdFlags(fs, args)
+	if *outputFile == "" {
+		failUsage(fs, "fixcrc needs an output file")
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	fixed, report, err := cfg.FixChecksum(b)
+	if err != nil {
+		fail(fmt.Errorf("fix checksum: %w", err), nil, nil)
+	}
+
+	if err := writeFileNoTrunc(*outputFile, fixed); err != nil {
+		fail(err, nil, nil)
+	}
+	done(report, nil, nil, false, func() {
+		fmt.Println(report)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type fixtureResult struct {
+	Dir   string   `json:"dir"`
+	Model string   `json:"model"`
+	Files []string `json:"files"`
+
+	// Number of secrets replaced with placeholders
+	Redacted int `json:"redacted"`
+}
+
+func fixtureCmd(args []string) {
+	fs := flag.NewFlagSet("fixture", flag.ExitOnError)
+	dir := fs.String("dir", filepath.Join("cfg", "testdata"), "test data directory to create the device's directory in")
+	modelName := fs.String("model", "", "model of the device, which names its directory (default: identify from the config)")
+	soap := fs.Bool("soap", false, "the config was downloaded over SOAP instead of from the web interface")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg fixture [-model MODEL] [-soap] [-dir cfg/testdata] <config.cfg>")
+		fs.PrintDefaults()
+	}
+	name := parseCmdFlags(fs, args)
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	_, configBytes, metadata, err := cfg.Decrypt(b)
+	if err != nil {
+		fail(fmt.Errorf("decrypt config: %w", err), nil, metadata)
+	}
+	if *soap {
+		if metadata.Origin != cfg.OriginBare {
+			fail(fmt.Errorf("config has a %q container, so it wasn't downloaded over SOAP", "photos.tar"), nil, metadata)
+		}
+		metadata.Origin = cfg.OriginSoap
+	}
+	c, err := cfg.NewConfig(configBytes, metadata)
+	if err != nil {
+		fail(fmt.Errorf("parse config: %w", err), nil, metadata)
+	}
+
+	var warnings []string
+	model := *modelName
+	if model == "" {
+		m := c.Model()
+		if m == nil {
+			failUsage(fs, "the model of the config can't be identified; pass -model")
+		}
+		model = m.Name
+	} else if cfg.LookupModel(model) == nil {
+		warnings = append(warnings, fmt.Sprintf("warning: %s isn't a known model; the tests expect it to be added to cfg/models.go", model))
+	}
+
+	// Redaction keeps the length of each value, so the fixture has the same layout as the original
+	redacted, secrets, err := cfg.Redact(configBytes)
+	if err != nil {
+		fail(fmt.Errorf("redact config: %w", err), warnings, metadata)
+	}
+	// Placeholders may not be valid values
+	encryptedConfig, err := cfg.EncryptVerified(redacted, metadata, cfg.NoValidate())
+	if err != nil {
+		fail(&hintError{err: fmt.Errorf("encrypt config: %w", err), hint: openIssueMsg}, warnings, metadata)
+	}
+	// Fixtures are meant to hold placeholders, so they aren't marked as redacted
+	wrapperJSON, err := cfg.ToJSON(redacted, metadata, false, cfg.AllowRedacted())
+	if err != nil {
+		fail(fmt.Errorf("create json wrapper: %w", err), warnings, metadata)
+	}
+	wrapperJSONRaw, err := cfg.ToJSON(redacted, metadata, true, cfg.AllowRedacted())
+	if err != nil {
+		fail(fmt.Errorf("create json wrapper: %w", err), warnings, metadata)
+	}
+
+	// File names expected by cfg/cfg_test.go
+	suffix := ""
+	if *soap {
+		suffix = "_soap"
+	}
+	r := fixtureResult{Dir: filepath.Join(*dir, model), Model: model, Redacted: len(secrets)}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		fail(err, warnings, metadata)
+	}
+	for _, f := range []namedFile{
+		{"encrypted" + suffix + ".cfg", encryptedConfig},
+		{"decrypted" + suffix + ".json", wrapperJSON},
+		{"decrypted" + suffix + "_raw.json", wrapperJSONRaw},
+	} {
+		path := filepath.Join(r.Dir, f.name)
+		if err := writeFileNoTrunc(path, f.data); err != nil {
+			fail(err, warnings, metadata)
+		}
+		r.Files = append(r.Files, path)
+	}
+
+	warnings = append(warnings, "note: only secrets were redacted. Review the fixture for other personal data, such as SSIDs and MAC addresses, before sharing it.")
+	done(r, warnings, metadata, false, func() {
+		fmt.Printf("redacted %v secrets and wrote a %s fixture to %s:\n", r.Redacted, model, r.Dir)
+		for _, f := range r.Files {
+			fmt.Printf("  %s\n", f)
+		}
+	})
+}
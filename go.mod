@@ -2,14 +2,16 @@ module github.com/fysac/orbicfg
 
 go 1.19
 
+require (
+	github.com/stretchr/testify v1.8.1
+	github.com/wk8/go-ordered-map/v2 v2.1.5
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/bahlo/generic-list-go v0.2.0 // indirect
 	github.com/buger/jsonparser v1.1.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.1 // indirect
-	github.com/wk8/go-ordered-map v1.0.0 // indirect
-	github.com/wk8/go-ordered-map/v2 v2.1.5 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
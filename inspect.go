@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+type inspectResult struct {
+	File string `json:"file"`
+
+	// "encrypted" or "wrapper"
+	Kind string `json:"kind"`
+
+	// Only set for encrypted configs
+	Header *cfg.Header `json:"header,omitempty"`
+
+	Model   string `json:"model,omitempty"`
+	Entries int    `json:"entries"`
+
+	// SHA-256 of the encrypted config a wrapper was decrypted from, if recorded
+	SourceSHA256 string          `json:"source_sha256,omitempty"`
+	Provenance   *cfg.Provenance `json:"provenance,omitempty"`
+}
+
+func inspectCmd(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg inspect <config.cfg|wrapper.json>")
+		fs.PrintDefaults()
+	}
+	name := parseCmdFlags(fs, args)
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	r := inspectResult{File: name}
+	var configBytes []byte
+	var metadata *cfg.Metadata
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		r.Kind = "wrapper"
+		// Show the wrapper as it is, even if it wouldn't be accepted for encryption
+		configBytes, metadata, err = cfg.FromJSON(b, cfg.Unchecked())
+		if err != nil {
+			err = fmt.Errorf("parse json wrapper: %w", err)
+			if exitCode(err) == exitFailed {
+				err = &parseError{err}
+			}
+			fail(err, nil, nil)
+		}
+		// The wrapper was just parsed, so these can't fail
+		integrity, _ := cfg.ReadIntegrity(b)
+		if integrity != nil {
+			r.SourceSHA256 = integrity.SourceSHA256
+		}
+		r.Provenance, _ = cfg.ReadProvenance(b)
+	} else {
+		r.Kind = "encrypted"
+		r.Header, configBytes, metadata, err = cfg.Decrypt(b)
+		if err != nil {
+			fail(fmt.Errorf("decrypt config: %w", err), nil, metadata)
+		}
+	}
+
+	c, err := cfg.NewConfig(configBytes, metadata)
+	if err != nil {
+		fail(fmt.Errorf("parse config: %w", err), nil, metadata)
+	}
+	r.Entries = c.Len()
+	if model := c.Model(); model != nil {
+		r.Model = model.Name
+	}
+
+	done(r, nil, metadata, false, func() {
+		printInspectText(&r, metadata)
+	})
+}
+
+func printInspectText(r *inspectResult, metadata *cfg.Metadata) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "file:\t%s (%s)\n", r.File, r.Kind)
+	model := r.Model
+	if model == "" {
+		model = "unknown"
+	}
+	fmt.Fprintf(w, "model:\t%s\n", model)
+	fmt.Fprintf(w, "entries:\t%v\n", r.Entries)
+	if r.SourceSHA256 != "" {
+		fmt.Fprintf(w, "source sha256:\t%s\n", r.SourceSHA256)
+	}
+	if r.Header != nil {
+		fmt.Fprintf(w, "header:\tmagic %#08x, len %v, crc %#08x\n", r.Header.Magic, r.Header.Len, r.Header.Crc)
+	}
+	fmt.Fprintf(w, "origin:\t%s (header at offset %v)\n", metadata.Origin, metadata.HeaderOffset)
+	fmt.Fprintf(w, "rng:\t%s, magic %#08x (stated %#08x)\n", metadata.Rng, metadata.RealMagic, metadata.StatedMagic)
+	endian, wordSize := metadata.Endian, metadata.WordSize
+	if endian == "" {
+		endian = cfg.EndianLittle
+	}
+	if wordSize == 0 {
+		wordSize = 4
+	}
+	fmt.Fprintf(w, "cipher:\t%s-endian, %v-byte words\n", endian, wordSize)
+	if metadata.Trailer != nil {
+		fmt.Fprintf(w, "trailer:\t%v bytes\n", metadata.Trailer.Len)
+	}
+
+	if p := r.Provenance; p != nil {
+		fmt.Fprintln(w, "provenance:")
+		if p.SourceName != "" {
+			fmt.Fprintf(w, "  source:\t%s\n", p.SourceName)
+		}
+		fmt.Fprintf(w, "  export type:\t%s\n", p.ExportType)
+		fmt.Fprintf(w, "  decrypted:\t%s\n", p.Decrypted.Format(time.RFC3339))
+		fmt.Fprintf(w, "  orbicfg version:\t%s\n", p.Version)
+		if p.Model != "" {
+			fmt.Fprintf(w, "  model:\t%s\n", p.Model)
+		}
+	} else if r.Kind == "wrapper" {
+		fmt.Fprintln(w, "provenance:\tnot recorded")
+	}
+	w.Flush()
+}
@@ -1,79 +1,114 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/fysac/orbicfg/cfg"
 )
 
+// Appended to the output file name to get the file that -redact saves secrets to
+const secretsFileSuffix = ".secrets.json"
+
 const openIssueMsg = `
 Please open a bug report at https://github.com/Fysac/orbicfg/issues.
-Include the exact command that failed, the error message, and the the model and firmware version of your device.`
+Include the exact command that failed, the error message, and the model and firmware version of your device.
+Running "orbicfg report <config.cfg>" collects these (without your config values) into a file you can attach.`
+
+var l = log.New(os.Stderr, "", 0)
+
+// Subcommands, invoked as `orbicfg <command> [flags] <file>`
+var commands = map[string]func(args []string){
+	"audit":     auditCmd,
+	"check":     checkCmd,
+	"decrypt":   decryptCmd,
+	"encrypt":   encryptCmd,
+	"fixcrc":    fixcrcCmd,
+	"fixture":   fixtureCmd,
+	"inspect":   inspectCmd,
+	"preflight": preflightCmd,
+	"report":    reportCmd,
+	"schema":    schemaCmd,
+	"secrets":   secretsCmd,
+}
 
 func main() {
-	l := log.New(os.Stderr, "", 0)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			command = os.Args[1]
+			cmd(os.Args[2:])
+			return
+		}
+	}
 
 	decryptFile := flag.String("decrypt", "", "file to decrypt (requires: -out)")
 	encryptFile := flag.String("encrypt", "", "file to encrypt (requires: -out, -magic)")
-	raw := flag.Bool("raw", false, "decrypt the raw bytes to a Base64-encoded field")
+	decryptOpts := addDecryptFlags(flag.CommandLine)
+	encryptOpts := addEncryptFlags(flag.CommandLine)
 	outputFile := flag.String("out", "", "output file for decryption or encryption")
+	addJSONFlag(flag.CommandLine)
 	flag.Parse()
 
 	if *decryptFile != "" {
+		command = "decrypt"
 		if *outputFile == "" {
-			l.Println("-decrypt needs an output file")
-			flag.Usage()
-			os.Exit(1)
+			failUsage(flag.CommandLine, "-decrypt needs an output file")
 		}
-
-		b, err := os.ReadFile(*decryptFile)
-		if err != nil {
-			l.Fatal(err)
-		}
-		_, configBytes, metadata, err := cfg.Decrypt(b)
+		warnings, metadata, err := decryptToFile(*decryptFile, *outputFile, decryptOpts)
 		if err != nil {
-			l.Println("decrypt config:", err)
-			l.Fatalln(openIssueMsg)
-		}
-		wrapperJSON, err := cfg.ToJSON(configBytes, metadata, *raw)
-		if err != nil {
-			l.Println("create json wrapper:", err)
-			l.Fatalln(openIssueMsg)
-		}
-
-		if err := writeFileNoTrunc(*outputFile, wrapperJSON); err != nil {
-			l.Fatal(err)
+			fail(err, warnings, metadata)
 		}
+		done(nil, warnings, metadata, false, nil)
 	} else if *encryptFile != "" {
+		command = "encrypt"
 		if *outputFile == "" {
-			l.Println("-encrypt needs an output file")
-			flag.Usage()
-			os.Exit(1)
-		}
-
-		wrapperJSON, err := os.ReadFile(*encryptFile)
-		if err != nil {
-			l.Fatal(err)
-		}
-		configBytes, metadata, err := cfg.FromJSON(wrapperJSON)
-		if err != nil {
-			l.Fatalln("parse json wrapper:", err)
+			failUsage(flag.CommandLine, "-encrypt needs an output file")
 		}
-		encryptedConfig, err := cfg.Encrypt(configBytes, metadata)
+		encryptOpts.checkFlags(flag.CommandLine)
+		metadata, err := encryptToFile(*encryptFile, *outputFile, encryptOpts)
 		if err != nil {
-			l.Println("encrypt config:", err)
-			l.Fatalln(openIssueMsg)
-		}
-
-		if err := writeFileNoTrunc(*outputFile, encryptedConfig); err != nil {
-			l.Fatal(err)
+			fail(err, nil, metadata)
 		}
+		done(nil, nil, metadata, false, nil)
 	} else {
-		flag.Usage()
-		os.Exit(1)
+		failUsage(flag.CommandLine, "either -decrypt or -encrypt is needed")
+	}
+}
+
+// hintError is an error with a suggestion for the user, printed after the error.
+type hintError struct {
+	err  error
+	hint string
+}
+
+func (e *hintError) Error() string {
+	return e.err.Error()
+}
+
+func (e *hintError) Unwrap() error {
+	return e.err
+}
+
+// locationHint explains where in a file the problem described by err is, if it's known.
+// configName describes the decrypted config, which is where entry offsets point.
+func locationHint(err error, configName string) string {
+	var headerErr *cfg.HeaderError
+	var entryErr *cfg.EntryError
+	var dupErr *cfg.DuplicateKeyError
+	switch {
+	case errors.As(err, &headerErr):
+		return fmt.Sprintf("The header is expected at byte offset %v of the encrypted file (%#x).", headerErr.Offset, headerErr.Offset)
+	case errors.As(err, &entryErr):
+		return fmt.Sprintf("The malformed entry starts at byte offset %v of %s.", entryErr.Offset, configName)
+	case errors.As(err, &dupErr):
+		return fmt.Sprintf("The duplicate entries start at byte offsets %v and %v of %s.", dupErr.Offsets[0], dupErr.Offsets[1], configName)
 	}
+	return ""
 }
 
 func writeFileNoTrunc(name string, b []byte) error {
@@ -86,3 +121,50 @@ func writeFileNoTrunc(name string, b []byte) error {
 	}
 	return f.Close()
 }
+
+// readConfig loads a decrypted config from either an encrypted config or a JSON wrapper.
+func readConfig(name string) (configBytes []byte, metadata *cfg.Metadata, err error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		// Only encryption needs valid values and restored secrets
+		configBytes, metadata, err = cfg.FromJSON(b, cfg.NoValidate(), cfg.AllowRedacted())
+		if err != nil {
+			err = fmt.Errorf("parse json wrapper: %w", err)
+			if exitCode(err) == exitFailed {
+				err = &parseError{err}
+			}
+			return nil, nil, err
+		}
+		return configBytes, metadata, nil
+	}
+	_, configBytes, metadata, err = cfg.Decrypt(b)
+	if err != nil {
+		return nil, metadata, fmt.Errorf("decrypt config: %w", err)
+	}
+	return configBytes, metadata, nil
+}
+
+// parseCmdFlags parses the flags of a subcommand, including -json, which must be followed by exactly one file.
+func parseCmdFlags(fs *flag.FlagSet, args []string) string {
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		failUsage(fs, fmt.Sprintf("%s needs exactly one file", fs.Name()))
+	}
+	return fs.Arg(0)
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"os"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+// Exit codes. Scripts may rely on these, so they must not change.
+const (
+	exitOK = 0
+
+	// An error not covered by another code, or a check (audit, check, preflight) that didn't pass
+	exitFailed = 1
+
+	// Bad flags or arguments
+	exitUsage = 2
+
+	// A file couldn't be read or written
+	exitIO = 3
+
+	// The container or header of an encrypted config is malformed
+	exitHeader = 4
+
+	// The checksum of an encrypted config doesn't match its contents
+	exitChecksum = 5
+
+	// A decrypted config, JSON wrapper, or other input couldn't be parsed
+	exitParse = 6
+
+	// A config was refused because of its values, protected keys, or metadata
+	exitValidation = 7
+
+	// The output file already exists
+	exitOverwrite = 8
+)
+
+var errorClasses = map[int]string{
+	exitFailed:     "error",
+	exitUsage:      "usage",
+	exitIO:         "io",
+	exitHeader:     "header",
+	exitChecksum:   "checksum",
+	exitParse:      "parse",
+	exitValidation: "validation",
+	exitOverwrite:  "overwrite",
+}
+
+// Name of the running command, and whether it should print a JSON result
+var (
+	command    = "orbicfg"
+	jsonOutput bool
+)
+
+// jsonResult is printed to stdout by every command when -json is given.
+type jsonResult struct {
+	Command string `json:"command"`
+
+	// "ok", "failed" (the command ran, but its check didn't pass), or "error"
+	Status string `json:"status"`
+
+	// Set if Status is "error"
+	ErrorCode  int      `json:"error_code,omitempty"`
+	ErrorClass string   `json:"error_class,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Hint       string   `json:"hint,omitempty"`
+	Key        string   `json:"key,omitempty"`
+	Offsets    []uint64 `json:"offsets,omitempty"`
+
+	Warnings []string      `json:"warnings,omitempty"`
+	Metadata *cfg.Metadata `json:"metadata,omitempty"`
+	Result   interface{}   `json:"result,omitempty"`
+}
+
+// addJSONFlag defines the -json flag, which every command has.
+func addJSONFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonOutput, "json", false, "print a JSON result to stdout")
+}
+
+// parseError marks errors in input that can't be recognized by their type, e.g., a malformed wrapper.
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string {
+	return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for the class of err.
+func exitCode(err error) int {
+	var headerErr *cfg.HeaderError
+	var entryErr *cfg.EntryError
+	var dupErr *cfg.DuplicateKeyError
+	var rngErr *cfg.UnsupportedRNGError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var parseErr *parseError
+	var validationErrs cfg.ValidationErrors
+	var protectedErr *cfg.ProtectedKeyError
+	var redactedErr *cfg.RedactedError
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return exitOverwrite
+	case errors.As(err, &headerErr):
+		return exitHeader
+	case errors.Is(err, cfg.ErrInvalidChecksum):
+		return exitChecksum
+	case errors.As(err, &entryErr), errors.As(err, &dupErr), errors.As(err, &rngErr),
+		errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.As(err, &parseErr):
+		return exitParse
+	case errors.As(err, &validationErrs), errors.As(err, &protectedErr), errors.As(err, &redactedErr),
+		errors.Is(err, cfg.ErrMetadataModified), errors.Is(err, cfg.ErrSalvaged):
+		return exitValidation
+	case errors.As(err, &pathErr):
+		return exitIO
+	}
+	return exitFailed
+}
+
+// errorLocation returns the key and offsets of the problem described by err, if they're known.
+func errorLocation(err error) (key string, offsets []uint64) {
+	var headerErr *cfg.HeaderError
+	var entryErr *cfg.EntryError
+	var dupErr *cfg.DuplicateKeyError
+	var protectedErr *cfg.ProtectedKeyError
+	var redactedErr *cfg.RedactedError
+	switch {
+	case errors.As(err, &headerErr):
+		return "", []uint64{headerErr.Offset}
+	case errors.As(err, &entryErr):
+		return entryErr.Key, []uint64{uint64(entryErr.Offset)}
+	case errors.As(err, &dupErr):
+		for _, o := range dupErr.Offsets {
+			offsets = append(offsets, uint64(o))
+		}
+		return dupErr.Key, offsets
+	case errors.As(err, &protectedErr):
+		return protectedErr.Key, nil
+	case errors.As(err, &redactedErr):
+		return redactedErr.Keys[0], nil
+	}
+	return "", nil
+}
+
+// fail reports err, along with any warnings and the metadata detected before it happened,
+// and exits with the code for its class.
+func fail(err error, warnings []string, metadata *cfg.Metadata) {
+	code := exitCode(err)
+	var hint string
+	var h *hintError
+	if errors.As(err, &h) {
+		hint = h.hint
+	}
+
+	if !jsonOutput {
+		for _, w := range warnings {
+			l.Println(w)
+		}
+		l.Println(err)
+		if hint != "" {
+			l.Println(hint)
+		}
+		os.Exit(code)
+	}
+
+	r := jsonResult{
+		Command:    command,
+		Status:     "error",
+		ErrorCode:  code,
+		ErrorClass: errorClasses[code],
+		Error:      err.Error(),
+		Hint:       hint,
+		Warnings:   warnings,
+		Metadata:   metadata,
+	}
+	r.Key, r.Offsets = errorLocation(err)
+	printResult(r)
+	os.Exit(code)
+}
+
+// failUsage reports a problem with the flags or arguments of a command and exits.
+func failUsage(fs *flag.FlagSet, msg string) {
+	if jsonOutput {
+		printResult(jsonResult{Command: command, Status: "error", ErrorCode: exitUsage, ErrorClass: errorClasses[exitUsage], Error: msg})
+		os.Exit(exitUsage)
+	}
+	l.Println(msg)
+	fs.Usage()
+	os.Exit(exitUsage)
+}
+
+// done reports the result of a command that ran to completion, using printText unless -json is given.
+// If failed is set, the command's check didn't pass, and it exits with exitFailed.
+func done(result interface{}, warnings []string, metadata *cfg.Metadata, failed bool, printText func()) {
+	if jsonOutput {
+		r := jsonResult{Command: command, Status: "ok", Warnings: warnings, Metadata: metadata, Result: result}
+		if failed {
+			r.Status = "failed"
+		}
+		printResult(r)
+	} else {
+		for _, w := range warnings {
+			l.Println(w)
+		}
+		if printText != nil {
+			printText()
+		}
+	}
+	if failed {
+		os.Exit(exitFailed)
+	}
+}
+
+func printResult(r jsonResult) {
+	if err := printJSON(r); err != nil {
+		l.Println(err)
+		os.Exit(exitIO)
+	}
+}
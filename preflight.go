@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+func preflightCmd(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	modelName := fs.String("model", "", "model of the device the config will be restored to (default: identify from the config)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg preflight [-model MODEL] <config.cfg>")
+		fs.PrintDefaults()
+	}
+	name := parseCmdFlags(fs, args)
+
+	var model *cfg.Model
+	if *modelName != "" {
+		if model = cfg.LookupModel(*modelName); model == nil {
+			failUsage(fs, fmt.Sprintf("unknown model %q", *modelName))
+		}
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	result := cfg.Preflight(b, model)
+
+	done(result, nil, nil, !result.Passed, func() {
+		for _, c := range result.Checks {
+			if c.Passed {
+				fmt.Printf("PASS  %s\n", c.Name)
+			} else {
+				fmt.Printf("FAIL  %s: %s\n", c.Name, c.Reason)
+			}
+		}
+		if !result.Passed {
+			fmt.Println("preflight failed")
+			return
+		}
+		fmt.Printf("preflight passed for %s\n", result.Model)
+	})
+}
@@ -3,13 +3,30 @@
 
 package musl
 
-var state uint64
+type RandomData struct {
+	state uint64
+}
+
+// Global state, for Srand and Rand
+var rd RandomData
 
+// Srand seeds the global generator.
 func Srand(seed uint32) {
-	state = uint64(seed - 1)
+	rd = *NewRandomData(seed)
 }
 
+// Rand returns the next value of the global generator.
 func Rand() int32 {
-	state = 6364136223846793005*state + 1
-	return int32(state >> 33)
+	return rd.Rand()
+}
+
+// NewRandomData returns a generator seeded with seed. Unlike the global one,
+// separate generators can be used concurrently.
+func NewRandomData(seed uint32) *RandomData {
+	return &RandomData{state: uint64(seed - 1)}
+}
+
+func (rd *RandomData) Rand() int32 {
+	rd.state = 6364136223846793005*rd.state + 1
+	return int32(rd.state >> 33)
 }
@@ -22,10 +22,22 @@ type RandomData struct {
 	state    [deg3]int32
 }
 
-// Global state
+// Global state, for Srand and Rand
 var rd *RandomData
 
+// Srand seeds the global generator.
 func Srand(seed uint32) {
+	rd = NewRandomData(seed)
+}
+
+// Rand returns the next value of the global generator.
+func Rand() int32 {
+	return rd.Rand()
+}
+
+// NewRandomData returns a generator seeded with seed. Unlike the global one,
+// separate generators can be used concurrently.
+func NewRandomData(seed uint32) *RandomData {
 	state := randtbl
 	kc := int32(deg3)
 
@@ -47,7 +59,7 @@ func Srand(seed uint32) {
 		state[uint(i)] = int32(word)
 	}
 
-	rd = &RandomData{
+	rd := &RandomData{
 		frontIdx: sep3,
 		rearIdx:  0,
 		state:    state,
@@ -55,12 +67,13 @@ func Srand(seed uint32) {
 
 	kc = kc*10 - 1
 	for kc >= 0 {
-		Rand()
+		rd.Rand()
 		kc -= 1
 	}
+	return rd
 }
 
-func Rand() int32 {
+func (rd *RandomData) Rand() int32 {
 	val := rd.state[rd.frontIdx] + rd.state[rd.rearIdx]
 	rd.state[rd.frontIdx] = val
 	result := (val >> 1) & 0x7fffffff
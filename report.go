@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+// Only the start of the plaintext goes into a bug report, with every value masked.
+const reportSampleLen = 4096
+
+// bugReport is saved as report.json in the archive written by the report command.
+// It must never hold config values, so that it can be attached to public issues.
+type bugReport struct {
+	Version   string   `json:"version"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Args      []string `json:"args"`
+
+	// The orbicfg command that failed, if given after --
+	Command []string `json:"command,omitempty"`
+
+	InputName   string `json:"input_name"`
+	InputSize   int    `json:"input_size"`
+	InputSHA256 string `json:"input_sha256"`
+
+	Header   *cfg.Header   `json:"header,omitempty"`
+	Metadata *cfg.Metadata `json:"metadata,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// Set if decryption succeeded
+	ConfigLen int    `json:"config_len,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Firmware  string `json:"firmware,omitempty"`
+	SampleLen int    `json:"sample_len,omitempty"`
+}
+
+func reportCmd(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	outputFile := fs.String("out", "", "output file (default: <config>.report.tar.gz)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg report [-out report.tar.gz] <config.cfg> [-- <command that failed>...]")
+		fs.PrintDefaults()
+	}
+	var command []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, command = args[:i], args[i+1:]
+			break
+		}
+	}
+	name := parseCmdFlags(fs, args)
+	if *outputFile == "" {
+		*outputFile = name + ".report.tar.gz"
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		fail(err, nil, nil)
+	}
+	sum := sha256.Sum256(b)
+	r := &bugReport{
+		Version:     cfg.Version(),
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Args:        os.Args,
+		Command:     command,
+		InputName:   filepath.Base(name),
+		InputSize:   len(b),
+		InputSHA256: hex.EncodeToString(sum[:]),
+	}
+
+	// A failed decryption is what the report is for, so it's recorded instead of stopping the command
+	var trace bytes.Buffer
+	header, configBytes, metadata, err := cfg.Decrypt(b, cfg.WithTracer(log.New(&trace, "", 0)))
+	r.Header, r.Metadata = header, metadata
+	// A config that failed to decrypt isn't sampled: the output of a wrong RNG
+	// can be turned back into the plaintext by anyone who knows the magic
+	var sample []byte
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.ConfigLen = len(configBytes)
+		if c, err := cfg.NewConfig(configBytes, metadata); err == nil {
+			if model := c.Model(); model != nil {
+				r.Model = model.Name
+				if model.FirmwareKey != "" {
+					r.Firmware, _ = c.Get(model.FirmwareKey)
+				}
+			}
+		}
+		sample = configBytes
+		if len(sample) > reportSampleLen {
+			sample = sample[:reportSampleLen]
+		}
+		sample = cfg.MaskValues(sample)
+		r.SampleLen = len(sample)
+	}
+
+	archive, err := writeReportArchive(r, trace.Bytes(), sample)
+	if err != nil {
+		fail(err, nil, metadata)
+	}
+	if err := writeFileNoTrunc(*outputFile, archive); err != nil {
+		fail(err, nil, metadata)
+	}
+	done(r, nil, metadata, false, func() {
+		if r.Error != "" {
+			l.Printf("decryption failed: %v", r.Error)
+		}
+		fmt.Printf("wrote %s; please attach it to your bug report\n", *outputFile)
+	})
+}
+
+// writeReportArchive creates a .tar.gz holding report.json, the decryption trace, and the masked plaintext sample.
+func writeReportArchive(r *bugReport, trace []byte, sample []byte) ([]byte, error) {
+	reportJSON, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	files := []namedFile{
+		{"report.json", append(reportJSON, '\n')},
+		{"trace.txt", trace},
+	}
+	if sample != nil {
+		files = append(files, namedFile{"sample.bin", sample})
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.data)), ModTime: now}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// namedFile is a file to be written, e.g., into an archive.
+type namedFile struct {
+	name string
+	data []byte
+}
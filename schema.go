@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+func schemaCmd(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg schema > wrapper.schema.json")
+		fs.PrintDefaults()
+	}
+	addJSONFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		failUsage(fs, "schema takes no arguments")
+	}
+
+	// With -json, the schema is the result
+	schema := cfg.WrapperSchema()
+	done(json.RawMessage(schema), nil, nil, false, func() {
+		if _, err := os.Stdout.Write(schema); err != nil {
+			fail(err, nil, nil)
+		}
+	})
+}
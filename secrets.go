@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fysac/orbicfg/cfg"
+)
+
+func secretsCmd(args []string) {
+	fs := flag.NewFlagSet("secrets", flag.ExitOnError)
+	nonEmpty := fs.Bool("nonempty", false, "only list credentials with a non-empty secret")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: orbicfg secrets [-nonempty] <config.cfg|wrapper.json>")
+		fs.PrintDefaults()
+	}
+	name := parseCmdFlags(fs, args)
+
+	configBytes, metadata, err := readConfig(name)
+	if err != nil {
+		fail(err, nil, metadata)
+	}
+	creds, err := cfg.Credentials(configBytes, *nonEmpty)
+	if err != nil {
+		fail(fmt.Errorf("list credentials: %w", err), nil, metadata)
+	}
+
+	done(creds, nil, metadata, false, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tUSERNAME\tSECRET")
+		for _, c := range creds {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Service, c.Username, c.Secret)
+		}
+		w.Flush()
+	})
+}